@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/dlabey/iam-git-auditor/pkg/utils"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Returns where a file of the Git work tree is, which is cloned into the temp dir.
+func worktreePath(name string) string {
+	return os.TempDir() + "/" + name
+}
+
+// Writes a file to the Git work tree, creating its directories, and adds it, returning false if it was unchanged.
+func writeFile(gitWorktree Worktree, name string, content []byte) bool {
+	file := worktreePath(name)
+	if existing, err := ioutil.ReadFile(file); err == nil && bytes.Equal(existing, content) {
+		log.Printf("msg=\"Nothing to change\" file=\"%s\"", name)
+
+		return false
+	}
+	err := os.MkdirAll(filepath.Dir(file), 0744)
+	utils.CheckError(err, "msg=\"Error creating directory\" err=\"%s\"")
+	err = ioutil.WriteFile(file, content, 0644)
+	utils.CheckError(err, "msg=\"Error writing file\" err=\"%s\"")
+	_, err = gitWorktree.Add(name)
+	utils.CheckError(err, "msg=\"Error adding file to Git work tree\" err=\"%s\"")
+	log.Printf("msg=\"Git Add\" file=\"%s\"", name)
+
+	return true
+}
+
+// Writes a value as indented JSON, whose object keys encoding/json sorts, so that diffs only show actual changes.
+func writeJSON(gitWorktree Worktree, name string, v interface{}) bool {
+	content, err := json.MarshalIndent(v, "", "  ")
+	utils.CheckError(err, "msg=\"Error marshalling JSON file\" err=\"%s\"")
+
+	return writeFile(gitWorktree, name, append(content, '\n'))
+}
+
+// Reads a JSON file of the Git work tree into a value, returning false if there is no such file.
+func readJSON(name string, v interface{}) bool {
+	content, err := ioutil.ReadFile(worktreePath(name))
+	if os.IsNotExist(err) {
+		return false
+	}
+	utils.CheckError(err, "msg=\"Error reading JSON file\" err=\"%s\"")
+	err = json.Unmarshal(content, v)
+	utils.CheckError(err, "msg=\"Error unmarshalling JSON file\" err=\"%s\"")
+
+	return true
+}
+
+// Writes a policy document as normalized JSON, or as is if it is not JSON.
+func writePolicyDocument(gitWorktree Worktree, name string, policyDocument string) bool {
+	var document interface{}
+	err := json.Unmarshal([]byte(policyDocument), &document)
+	if err != nil {
+		return writeFile(gitWorktree, name, []byte(policyDocument))
+	}
+
+	return writeJSON(gitWorktree, name, document)
+}
+
+// Removes a file or directory from the Git work tree, returning false if there was nothing to remove.
+func removeFile(gitWorktree Worktree, name string) bool {
+	if _, err := os.Stat(worktreePath(name)); os.IsNotExist(err) {
+		log.Printf("msg=\"Nothing to remove\" file=\"%s\"", name)
+
+		return false
+	}
+	_, err := gitWorktree.Remove(name)
+	utils.CheckError(err, "msg=\"Error removing file from Git work tree\" err=\"%s\"")
+	log.Printf("msg=\"Git Remove\" file=\"%s\"", name)
+
+	return true
+}
+
+// Moves a file of the Git work tree into a directory of the same name, for layouts that turned a file into a directory,
+// returning false if there is no such file.
+func moveFileIntoDir(gitWorktree Worktree, name string, fileName string) bool {
+	file := worktreePath(name)
+	if info, err := os.Stat(file); err != nil || info.IsDir() {
+		return false
+	}
+	content, err := ioutil.ReadFile(file)
+	utils.CheckError(err, "msg=\"Error reading file\" err=\"%s\"")
+	_, err = gitWorktree.Remove(name)
+	utils.CheckError(err, "msg=\"Error removing file from Git work tree\" err=\"%s\"")
+	// Removing it from the Git work tree usually removes it from the file system already.
+	err = os.Remove(file)
+	if !os.IsNotExist(err) {
+		utils.CheckError(err, "msg=\"Error removing file\" err=\"%s\"")
+	}
+	log.Printf("msg=\"Git Move\" file=\"%s\" to=\"%s\"", name, name+"/"+fileName)
+
+	return writeFile(gitWorktree, name+"/"+fileName, content)
+}
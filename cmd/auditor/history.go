@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+	"sort"
+)
+
+// An incarnation of a principal, which IAM tells apart from others of the same name by its unique ID.
+type incarnation struct {
+	ID        string `json:"id"`
+	Arn       string `json:"arn,omitempty"`
+	CreatedAt string `json:"createdAt,omitempty"`
+	DeletedAt string `json:"deletedAt,omitempty"`
+}
+
+// Records an incarnation of a principal in its history file, merging it into what is known of the incarnation with
+// the same ID, so that deleted incarnations are kept as tombstones.
+func recordIncarnation(gitWorktree Worktree, historyFile string, current incarnation) {
+	var incarnations []incarnation
+	readJSON(historyFile, &incarnations)
+	found := false
+	for i := 0; i < len(incarnations); i++ {
+		if incarnations[i].ID != current.ID {
+			continue
+		}
+		found = true
+		if current.Arn != "" {
+			incarnations[i].Arn = current.Arn
+		}
+		if current.CreatedAt != "" {
+			incarnations[i].CreatedAt = current.CreatedAt
+		}
+		if current.DeletedAt != "" {
+			incarnations[i].DeletedAt = current.DeletedAt
+		}
+	}
+	if !found {
+		incarnations = append(incarnations, current)
+	}
+
+	// Incarnations created before the audit started have no creation time and sort first.
+	sort.SliceStable(incarnations, func(i, j int) bool {
+		return incarnations[i].CreatedAt < incarnations[j].CreatedAt
+	})
+	writeJSON(gitWorktree, historyFile, incarnations)
+}
+
+// Records the creation of a principal, returning a commit message trailer flagging it if its name was last used by a
+// principal with another unique ID, which policies naming the principal by ARN no longer grant anything to. A creation
+// delivered again is not flagged, as the principal it creates is still the current one.
+func recordCreation(gitWorktree Worktree, historyFile string, principal string, previousID string,
+	current incarnation) []string {
+	if current.ID == "" {
+		return nil
+	}
+
+	if previousID != "" && previousID != current.ID {
+		// The previous incarnation may have been deleted without its deletion being audited.
+		recordIncarnation(gitWorktree, historyFile, incarnation{ID: previousID})
+	} else if previousID == "" {
+		// The previous incarnation was deleted, so it is the latest one of the history.
+		var incarnations []incarnation
+		if readJSON(historyFile, &incarnations) && len(incarnations) > 0 {
+			previousID = incarnations[len(incarnations)-1].ID
+		}
+	}
+	recordIncarnation(gitWorktree, historyFile, current)
+	if previousID == "" || previousID == current.ID {
+		return nil
+	}
+	log.Printf("msg=\"Principal recreated with another unique ID\" principal=\"%s\" id=\"%s\" previousId=\"%s\"",
+		principal, current.ID, previousID)
+
+	return []string{"Recreated: " + principal + " was " + previousID + ", now " + current.ID}
+}
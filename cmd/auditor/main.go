@@ -3,31 +3,66 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/dlabey/iam-git-auditor/pkg/cloudtrail"
+	"github.com/dlabey/iam-git-auditor/pkg/queue"
 	"github.com/dlabey/iam-git-auditor/pkg/utils"
 	"gopkg.in/src-d/go-git.v4"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
 
+// The API version Lambda appends to the names of its events, e.g. AddPermission20150331v2.
+var apiVersionPattern = regexp.MustCompile(`\d{8}(v\d+)?$`)
+
 type response struct {
 	Added   int
 	Removed int
 	Ignored int
 }
 
+// Counts an addition, or ignores the event if there was nothing to add, returning whether there is a change to commit.
+func (r *response) add(added bool) bool {
+	if added {
+		r.Added++
+	} else {
+		r.Ignored++
+	}
+
+	return added
+}
+
+// Counts a removal, or ignores the event if there was nothing to remove, returning whether there is a change to commit.
+func (r *response) remove(removed bool) bool {
+	if removed {
+		r.Removed++
+	} else {
+		r.Ignored++
+	}
+
+	return removed
+}
+
 type token struct {
 	Token string `json:"token"`
 }
@@ -38,13 +73,265 @@ func parsePolicyName(policyArn string) string {
 	return segments[len(segments)-1]
 }
 
+// Returns the name of the managed policy of the request, which most managed policy events only give the ARN of.
+func requestPolicyName(requestParameters *cloudtrail.RequestParameters) string {
+	if requestParameters.PolicyArn != "" {
+		return parsePolicyName(requestParameters.PolicyArn)
+	}
+
+	return requestParameters.PolicyName
+}
+
+// Moves a policy file of the single file layout, which held the document of the default version, into the versions of
+// the policy directory as its default version, returning false if there is no such file.
+func migratePolicyFile(gitWorktree Worktree, policyDir string, versionsDirName string, defaultFileName string,
+	versionId string) bool {
+	if !moveFileIntoDir(gitWorktree, policyDir, versionsDirName+"/"+versionId+".json") {
+		return false
+	}
+	// The policy directory was a file until now, so it has no default version yet.
+	writeFile(gitWorktree, policyDir+"/"+defaultFileName, []byte(versionId))
+
+	return true
+}
+
+// Returns the user the request is for, which is the caller when an IAM user manages their own credentials.
+func requestUserName(cloudTrailEvt *cloudtrail.CloudTrailEvent) string {
+	if cloudTrailEvt.RequestParameters.UserName != "" {
+		return cloudTrailEvt.RequestParameters.UserName
+	}
+
+	return cloudTrailEvt.UserIdentity.UserName
+}
+
+// Adds or removes a name in a list file, kept sorted, returning false if the list is unchanged.
+func updateList(gitWorktree Worktree, listFile string, name string, listed bool) bool {
+	var names []string
+	readJSON(listFile, &names)
+	i := sort.SearchStrings(names, name)
+	isListed := i < len(names) && names[i] == name
+	if isListed == listed {
+		log.Printf("msg=\"List unchanged\" file=\"%s\" name=\"%s\"", listFile, name)
+
+		return false
+	}
+	if listed {
+		names = append(names[:i], append([]string{name}, names[i:]...)...)
+	} else {
+		names = append(names[:i], names[i+1:]...)
+	}
+	writeJSON(gitWorktree, listFile, names)
+
+	return true
+}
+
+// Returns a sorted copy of a list, which is empty rather than nil so that it is written as an empty JSON array.
+func sortedList(names []string) []string {
+	sorted := append([]string{}, names...)
+	sort.Strings(sorted)
+
+	return sorted
+}
+
+// Writes or, when the policy is set to nothing, removes a resource policy that is set as an attribute of its resource,
+// returning whether there is a change to commit.
+func setResourcePolicy(gitWorktree Worktree, response *response, policyFile string, policy string) bool {
+	if policy == "" {
+		return response.remove(removeFile(gitWorktree, policyFile))
+	}
+
+	return response.add(writePolicyDocument(gitWorktree, policyFile, policy))
+}
+
+// Returns where the policy of a regional resource is kept apart from resources of the same name in other accounts and
+// regions, which are those of the resource ARN when there is one and otherwise those the CloudTrail event was
+// recorded for.
+func regionalPolicyFile(dir string, cloudTrailEvt *cloudtrail.CloudTrailEvent, resourceArn string, name string) string {
+	accountID, region := cloudTrailEvt.RecipientAccountID, cloudTrailEvt.AWSRegion
+
+	// ARNs are made of arn:partition:service:region:account-id:resource.
+	segments := strings.SplitN(resourceArn, ":", 6)
+	if len(segments) == 6 && segments[0] == "arn" {
+		region, accountID = segments[3], segments[4]
+	}
+
+	return dir + "/" + accountID + "/" + region + "/" + name + ".json"
+}
+
+// Returns the statement Lambda added to the function policy, or the permission requested when the response has none.
+func permissionStatement(cloudTrailEvt *cloudtrail.CloudTrailEvent) interface{} {
+	var statement interface{}
+	if json.Unmarshal([]byte(cloudTrailEvt.ResponseElements.Statement), &statement) == nil {
+		return statement
+	}
+
+	permission := map[string]string{}
+	for name, value := range map[string]string{
+		"action":         cloudTrailEvt.RequestParameters.Action,
+		"principal":      cloudTrailEvt.RequestParameters.Principal,
+		"principalOrgID": cloudTrailEvt.RequestParameters.PrincipalOrgID,
+		"sourceAccount":  cloudTrailEvt.RequestParameters.SourceAccount,
+		"sourceArn":      cloudTrailEvt.RequestParameters.SourceArn,
+	} {
+		if value != "" {
+			permission[name] = value
+		}
+	}
+
+	return permission
+}
+
+// Sets or, given no statement, removes a statement of a policy file keyed by statement ID, removing the file once no
+// statements are left, returning false if the policy is unchanged.
+func updateStatement(gitWorktree Worktree, policyFile string, statementID string, statement interface{}) bool {
+	statements := map[string]interface{}{}
+	readJSON(policyFile, &statements)
+	if statement != nil {
+		statements[statementID] = statement
+	} else {
+		delete(statements, statementID)
+	}
+	if len(statements) == 0 {
+		return removeFile(gitWorktree, policyFile)
+	}
+
+	return writeJSON(gitWorktree, policyFile, statements)
+}
+
+// Returns the directory name of a permission set, which is its name once its creation has been audited or else its ID.
+// Identity Center events only name a permission set on its creation and otherwise give its ARN.
+func permissionSetName(permissionSetsDir string, permissionSetFileName string, permissionSetArn string) string {
+	permissionSetFiles, err := filepath.Glob(worktreePath(permissionSetsDir + "/*/" + permissionSetFileName))
+	utils.CheckError(err, "msg=\"Error listing permission set files\" err=\"%s\"")
+	for _, permissionSetFile := range permissionSetFiles {
+		name := filepath.Base(filepath.Dir(permissionSetFile))
+		var permissionSet cloudtrail.PermissionSet
+		readJSON(permissionSetsDir+"/"+name+"/"+permissionSetFileName, &permissionSet)
+		if permissionSet.PermissionSetArn == permissionSetArn {
+			return name
+		}
+	}
+
+	return path.Base(permissionSetArn)
+}
+
+// Returns an account assignment as a line of the assignments file, which is the account and principal it is for.
+func accountAssignment(requestParameters *cloudtrail.RequestParameters) string {
+	return requestParameters.TargetId + "/" + requestParameters.PrincipalType + "/" + requestParameters.PrincipalId
+}
+
+// Merges tags into the tags file of an entity and removes the tag keys from it, removing the file once no tags are
+// left, returning false if the tags are unchanged.
+func updateTags(gitWorktree Worktree, tagsFile string, tags []cloudtrail.Tag, tagKeys []string) bool {
+	tagMap := map[string]string{}
+	readJSON(tagsFile, &tagMap)
+	for _, tag := range tags {
+		tagMap[tag.Key] = tag.Value
+	}
+	for _, tagKey := range tagKeys {
+		delete(tagMap, tagKey)
+	}
+	if len(tagMap) == 0 {
+		return removeFile(gitWorktree, tagsFile)
+	}
+
+	return writeJSON(gitWorktree, tagsFile, tagMap)
+}
+
+// Returns the CloudTrail event of the SQS message, resolving it from the overflow bucket when the Tailer had to store
+// it there for being too large for SQS.
+func resolveBody(sqsMsg events.SQSMessage, s3Svc s3iface.S3API) ([]byte, error) {
+	overflowBucket := aws.StringValue(sqsMsg.MessageAttributes[queue.OverflowBucketAttribute].StringValue)
+	overflowKey := aws.StringValue(sqsMsg.MessageAttributes[queue.OverflowKeyAttribute].StringValue)
+	if overflowBucket == "" && overflowKey == "" {
+		return []byte(sqsMsg.Body), nil
+	}
+	if overflowBucket == "" || overflowKey == "" {
+		return nil, fmt.Errorf("overflow CloudTrail event needs both the %s and %s message attributes, got bucket "+
+			"\"%s\" and key \"%s\"", queue.OverflowBucketAttribute, queue.OverflowKeyAttribute, overflowBucket, overflowKey)
+	}
+	getObjectOutput, err := s3Svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(overflowBucket),
+		Key:    aws.String(overflowKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer getObjectOutput.Body.Close()
+	body, err := ioutil.ReadAll(getObjectOutput.Body)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("msg=\"Resolved overflow CloudTrail event\" overflowKey=\"%s\"", overflowKey)
+
+	return body, nil
+}
+
+// Returns the commit message of the CloudTrail event, with trailers for what the audit found and for what the Tailer
+// attached to its SQS message.
+func commitMessage(cloudTrailEvt *cloudtrail.CloudTrailEvent, sqsMsg events.SQSMessage, trailers ...string) string {
+	msg := cloudTrailEvt.EventName + " by " + cloudTrailEvt.UserIdentity.Name()
+	if validation, ok := sqsMsg.MessageAttributes[queue.LogFileValidationAttribute]; ok {
+		trailers = append(trailers, "Log-File-Validation: "+aws.StringValue(validation.StringValue))
+	}
+	if len(trailers) > 0 {
+		msg += "\n\n" + strings.Join(trailers, "\n")
+	}
+
+	return msg
+}
+
 // Audits the intended records in sequence for each to be a single Git commit for the right datetime.
 func Auditor(ctx context.Context, evt events.SQSEvent, gitAuth transport.AuthMethod, gitRepo Repository,
-	gitWorktree Worktree, iamSvc iamiface.IAMAPI) (*response, error) {
+	gitWorktree Worktree, iamSvc iamiface.IAMAPI, s3Svc s3iface.S3API) (*response, error) {
 	// Assign common constants.
+	const AccessKeysDirName = "accessKeys"
+	const AccountAliasFileName = "alias.json"
+	const AccountDirName = "account"
+	const AssumeRolePolicyDocumentFileName = "assumeRolePolicyDocument.json"
+	const AssignmentsFileName = "assignments.json"
 	const AttachedPoliciesDirName = "attachedPolicies"
+	const ClientIDsFileName = "clientIds.json"
+	const ContentFileName = "content.json"
+	const DefaultMinimumPasswordLength = 6
+	const DefaultPolicyVersionFileName = "default"
+	const GroupsDirName = "groups"
+	const HistoryDirName = "history"
+	const IAMEventSource = "iam.amazonaws.com"
+	const InlinePolicyFileName = "inlinePolicy.json"
+	const InlinePoliciesDirName = "inlinePolicies"
+	const InstanceProfilesDirName = "instanceProfiles"
+	const InstanceProfilesFileName = "instanceProfiles.json"
+	// The version of the document a policy file held when policies were kept as a single file.
+	const LegacyPolicyVersionId = "legacy"
+	const LoginProfileFileName = "loginProfile.json"
+	const ManagedPoliciesFileName = "managedPolicies.json"
+	const MembersFileName = "members.json"
+	const MFADevicesDirName = "mfaDevices"
+	const OpenIDConnectProvidersDirName = "identityProviders/oidc"
+	const OrganizationsPoliciesDirName = "organizations/policies"
+	const OrganizationsPolicyFileName = "policy.json"
+	const OrganizationsTargetsDirName = "organizations/targets"
+	const PasswordPolicyFileName = "passwordPolicy.json"
+	const PermissionsBoundaryFileName = "permissionsBoundary"
+	const PermissionSetFileName = "permissionSet.json"
+	const PermissionSetsDirName = "permissionSets"
 	const PoliciesDirName = "policies"
+	const PoliciesFileName = "policies.json"
+	const PolicyVersionsDirName = "versions"
+	const ResourcePoliciesDirName = "resourcePolicies"
+	const RoleFileName = "role.json"
 	const RolesDirName = "roles"
+	const ProvisioningFileName = "provisioning.json"
+	const RolesFileName = "roles.json"
+	const SAMLMetadataFileName = "metadata.xml"
+	const SAMLProvidersDirName = "identityProviders/saml"
+	const STSPreferencesFileName = "stsPreferences.json"
+	const TagsFileName = "tags.json"
+	const TargetsFileName = "targets.json"
+	const ThumbprintsFileName = "thumbprints.json"
+	const UserFileName = "user.json"
+	const UsersDirName = "users"
 
 	// Instantiate the response.
 	response := &response{}
@@ -52,135 +339,611 @@ func Auditor(ctx context.Context, evt events.SQSEvent, gitAuth transport.AuthMet
 	// Handle the event and commit it to the Git work tree.
 	for i := 0; i < len(evt.Records); i++ {
 		var cloudTrailEvt cloudtrail.CloudTrailEvent
-		err := json.Unmarshal([]byte(evt.Records[i].Body), &cloudTrailEvt)
+		body, err := resolveBody(evt.Records[i], s3Svc)
+		utils.CheckError(err, "msg=\"Error resolving CloudTrail event\" err=\"%s\"")
+		err = json.Unmarshal(body, &cloudTrailEvt)
 		utils.CheckError(err, "msg=\"Error unmarshalling CloudTrail event\" err=\"%s\"")
 		log.Printf("msg=\"Auditing CloudTrail event\" eventName=%s", cloudTrailEvt.EventName)
 		eventName := cloudTrailEvt.EventName
+		// Events of other services are told apart by their service, as some have the same names as IAM events.
+		if cloudTrailEvt.EventSource != "" && cloudTrailEvt.EventSource != IAMEventSource {
+			eventName = strings.TrimSuffix(cloudTrailEvt.EventSource, ".amazonaws.com") + ":" +
+				apiVersionPattern.ReplaceAllString(eventName, "")
+		}
 		validEvent := true
+		var trailers []string
 		switch eventName {
+		case "AddClientIDToOpenIDConnectProvider":
+			oidcProviderDir := OpenIDConnectProvidersDirName + "/" +
+				cloudtrail.IdentityProviderName(cloudTrailEvt.RequestParameters.OpenIDConnectProviderArn)
+			validEvent = response.add(updateList(gitWorktree, oidcProviderDir+"/"+ClientIDsFileName,
+				cloudTrailEvt.RequestParameters.ClientID, true))
+		case "AddRoleToInstanceProfile":
+			instanceProfileName := cloudTrailEvt.RequestParameters.InstanceProfileName
+			roleName := cloudTrailEvt.RequestParameters.RoleName
+			written := updateList(gitWorktree, InstanceProfilesDirName+"/"+instanceProfileName+"/"+RolesFileName,
+				roleName, true)
+			written = updateList(gitWorktree, RolesDirName+"/"+roleName+"/"+InstanceProfilesFileName,
+				instanceProfileName, true) || written
+			validEvent = response.add(written)
+		case "AddUserToGroup":
+			membersFile := GroupsDirName + "/" + cloudTrailEvt.RequestParameters.GroupName + "/" + MembersFileName
+			validEvent = response.add(updateList(gitWorktree, membersFile,
+				cloudTrailEvt.RequestParameters.UserName, true))
+		case "AttachGroupPolicy":
+			groupDir := GroupsDirName + "/" + cloudTrailEvt.RequestParameters.GroupName
+			policyName := parsePolicyName(cloudTrailEvt.RequestParameters.PolicyArn)
+			validEvent = response.add(writeFile(gitWorktree, groupDir+"/"+AttachedPoliciesDirName+"/"+policyName,
+				[]byte(cloudTrailEvt.RequestParameters.PolicyArn)))
 		case "AttachRolePolicy":
-			roleDir := os.TempDir() + "/" + RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
-			attachedPolicyFile := roleDir + "/" + AttachedPoliciesDirName + "/" + cloudTrailEvt.RequestParameters.PolicyName
-			attachedPolicyFileHandle, err := os.OpenFile(attachedPolicyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-			utils.CheckError(err, "msg=\"Error opening attached policy file\" err=\"%s\"")
-			_, err = attachedPolicyFileHandle.WriteString(cloudTrailEvt.RequestParameters.PolicyArn)
-			utils.CheckError(err, "msg=\"Error appending attached policy file\" err=\"%s\"")
-			err = attachedPolicyFileHandle.Close()
-			utils.CheckError(err, "msg=\"Error closing attached policy file\" err=\"%s\"")
-			_, err = gitWorktree.Add(attachedPolicyFile)
-			utils.CheckError(err, "msg=\"Error adding attached policy file to Git work tree\" err=\"%s\"")
-			log.Printf("msg=\"Git Add\" file=\"%s\"", attachedPolicyFile)
-			response.Added++
+			roleDir := RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
+			policyName := parsePolicyName(cloudTrailEvt.RequestParameters.PolicyArn)
+			validEvent = response.add(writeFile(gitWorktree, roleDir+"/"+AttachedPoliciesDirName+"/"+policyName,
+				[]byte(cloudTrailEvt.RequestParameters.PolicyArn)))
+		case "AttachUserPolicy":
+			userDir := UsersDirName + "/" + cloudTrailEvt.RequestParameters.UserName
+			policyName := parsePolicyName(cloudTrailEvt.RequestParameters.PolicyArn)
+			validEvent = response.add(writeFile(gitWorktree, userDir+"/"+AttachedPoliciesDirName+"/"+policyName,
+				[]byte(cloudTrailEvt.RequestParameters.PolicyArn)))
+		case "CreateAccessKey":
+			userDir := UsersDirName + "/" + requestUserName(&cloudTrailEvt)
+			accessKey := cloudTrailEvt.ResponseElements.AccessKey
+			if accessKey.AccessKeyID == "" {
+				log.Printf("msg=\"No access key in response\" userDir=\"%s\"", userDir)
+				validEvent = false
+				response.Ignored++
+
+				break
+			}
+			validEvent = response.add(writeJSON(gitWorktree,
+				userDir+"/"+AccessKeysDirName+"/"+accessKey.AccessKeyID+".json", accessKey))
+		case "CreateAccountAlias":
+			validEvent = response.add(writeJSON(gitWorktree, AccountDirName+"/"+AccountAliasFileName, map[string]string{
+				"accountAlias": cloudTrailEvt.RequestParameters.AccountAlias,
+			}))
+		case "CreateGroup":
+			groupDir := GroupsDirName + "/" + cloudTrailEvt.RequestParameters.GroupName
+			group := cloudTrailEvt.ResponseElements.Group
+			if group.GroupName == "" {
+				group.GroupName = cloudTrailEvt.RequestParameters.GroupName
+				group.Path = cloudTrailEvt.RequestParameters.Path
+			}
+			groupWritten := writeJSON(gitWorktree, groupDir+"/group.json", group)
+			membersWritten := writeJSON(gitWorktree, groupDir+"/"+MembersFileName, []string{})
+			validEvent = response.add(groupWritten || membersWritten)
+		case "CreateInstanceProfile":
+			instanceProfileDir := InstanceProfilesDirName + "/" + cloudTrailEvt.RequestParameters.InstanceProfileName
+			instanceProfile := cloudTrailEvt.ResponseElements.InstanceProfile
+			if instanceProfile.InstanceProfileName == "" {
+				instanceProfile.InstanceProfileName = cloudTrailEvt.RequestParameters.InstanceProfileName
+				instanceProfile.Path = cloudTrailEvt.RequestParameters.Path
+			}
+			written := writeJSON(gitWorktree, instanceProfileDir+"/instanceProfile.json", instanceProfile)
+			written = writeJSON(gitWorktree, instanceProfileDir+"/"+RolesFileName, []string{}) || written
+			validEvent = response.add(written)
+		case "CreateLoginProfile":
+			userDir := UsersDirName + "/" + requestUserName(&cloudTrailEvt)
+			loginProfile := cloudTrailEvt.ResponseElements.LoginProfile
+			if loginProfile.UserName == "" {
+				loginProfile.UserName = requestUserName(&cloudTrailEvt)
+				loginProfile.CreateDate = cloudTrailEvt.EventTime
+				loginProfile.PasswordResetRequired = cloudTrailEvt.RequestParameters.PasswordResetRequired
+			}
+			validEvent = response.add(writeJSON(gitWorktree, userDir+"/"+LoginProfileFileName, loginProfile))
+		case "CreateOpenIDConnectProvider":
+			oidcProviderName := cloudtrail.OpenIDConnectProviderName(cloudTrailEvt.RequestParameters.URL)
+			if cloudTrailEvt.ResponseElements.OpenIDConnectProviderArn != "" {
+				oidcProviderName = cloudtrail.IdentityProviderName(cloudTrailEvt.ResponseElements.OpenIDConnectProviderArn)
+			}
+			oidcProviderDir := OpenIDConnectProvidersDirName + "/" + oidcProviderName
+			written := writeJSON(gitWorktree, oidcProviderDir+"/"+ClientIDsFileName,
+				sortedList(cloudTrailEvt.RequestParameters.ClientIDList))
+			written = writeJSON(gitWorktree, oidcProviderDir+"/"+ThumbprintsFileName,
+				sortedList(cloudTrailEvt.RequestParameters.ThumbprintList)) || written
+			validEvent = response.add(written)
 		case "CreatePolicy":
-			policyFile := os.TempDir() + "/" + PoliciesDirName + "/" + cloudTrailEvt.RequestParameters.PolicyName
-			policyFileHandle, err := os.Create(policyFile)
-			utils.CheckError(err, "msg=\"Error creating policy file\" err=\"%s\"")
-			_, err = policyFileHandle.WriteString(cloudTrailEvt.RequestParameters.PolicyDocument)
-			utils.CheckError(err, "msg=\"Error writing policy file\" err=\"%s\"")
-			err = policyFileHandle.Close()
-			utils.CheckError(err, "msg=\"Error closing policy file\" err=\"%s\"")
-			_, err = gitWorktree.Add(policyFile)
-			utils.CheckError(err, "msg=\"Error adding policy file to Git work tree\" err=\"%s\"")
-			log.Printf("msg=\"Git Add\" file=\"%s\"", policyFile)
-			response.Added++
+			policyDir := PoliciesDirName + "/" + requestPolicyName(&cloudTrailEvt.RequestParameters)
+			migrated := migratePolicyFile(gitWorktree, policyDir, PolicyVersionsDirName, DefaultPolicyVersionFileName,
+				LegacyPolicyVersionId)
+			versionId := cloudTrailEvt.ResponseElements.Policy.DefaultVersionId
+			if versionId == "" {
+				versionId = "v1"
+			}
+			written := writePolicyDocument(gitWorktree, policyDir+"/"+PolicyVersionsDirName+"/"+versionId+".json",
+				cloudTrailEvt.RequestParameters.PolicyDocument) || migrated
+			written = writeFile(gitWorktree, policyDir+"/"+DefaultPolicyVersionFileName, []byte(versionId)) || written
+			if len(cloudTrailEvt.RequestParameters.Tags) > 0 {
+				written = updateTags(gitWorktree, policyDir+"/"+TagsFileName, cloudTrailEvt.RequestParameters.Tags,
+					nil) || written
+			}
+			validEvent = response.add(written)
 		case "CreatePolicyVersion":
-			policyFile := os.TempDir() + "/" + PoliciesDirName + "/" + cloudTrailEvt.RequestParameters.PolicyName
-			policyFileHandle, err := os.OpenFile(policyFile, os.O_WRONLY, 0644)
-			utils.CheckError(err, "msg=\"Error opening policy file\" err=\"%s\"")
-			err = policyFileHandle.Truncate(0)
-			utils.CheckError(err, "msg=\"Error truncating policy file\" err=\"%s\"")
-			_, err = policyFileHandle.Seek(0, 0)
-			utils.CheckError(err, "msg=\"Error seeking policy file\" err=\"%s\"")
-			_, err = policyFileHandle.WriteString(cloudTrailEvt.RequestParameters.PolicyDocument)
-			utils.CheckError(err, "msg=\"Error writing policy file\" err=\"%s\"")
-			_, err = gitWorktree.Add(policyFile)
-			utils.CheckError(err, "msg=\"Error adding policy file to Git work tree\" err=\"%s\"")
-			log.Printf("msg=\"Git Add\" file=\"%s\"", policyFile)
-			response.Added++
-		case "CreateRole":
-			roleDir := os.TempDir() + "/" + RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
-			if _, err := os.Stat(roleDir); os.IsNotExist(err) {
-				err = os.Mkdir(roleDir, 0744)
-				utils.CheckError(err, "msg=\"Error creating role directory\" err=\"%s\"")
-			}
-			inlinePolicyFile := roleDir + "/" + cloudTrailEvt.RequestParameters.RoleName
-			_, err = os.Create(inlinePolicyFile)
-			utils.CheckError(err, "msg=\"Error creating inline policy file\" err=\"%s\"")
-			status, err := gitWorktree.Status()
-			utils.CheckError(err, "msg=\"Error getting Git work tree status\" err=\"%s\"")
-			log.Printf("msg=\"Git Status\" status=\"%s\"", status)
-			if status.String() == "" {
+			policyDir := PoliciesDirName + "/" + requestPolicyName(&cloudTrailEvt.RequestParameters)
+			versionId := cloudTrailEvt.ResponseElements.PolicyVersion.VersionId
+			if versionId == "" {
+				log.Printf("msg=\"No policy version in response\" policyDir=\"%s\"", policyDir)
 				validEvent = false
 				response.Ignored++
-			} else {
-				inlinePolicyAdd := RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName + "/" +
-					cloudTrailEvt.RequestParameters.RoleName
-				_, err = gitWorktree.Add(inlinePolicyAdd)
-				utils.CheckError(err, "msg=\"Error adding inline policy file to Git work tree\" err=\"%s\"")
-				log.Printf("msg=\"Git Add\" file=\"%s\"", inlinePolicyFile)
-				response.Added++
+
+				break
+			}
+			migrated := migratePolicyFile(gitWorktree, policyDir, PolicyVersionsDirName, DefaultPolicyVersionFileName,
+				LegacyPolicyVersionId)
+			written := writePolicyDocument(gitWorktree, policyDir+"/"+PolicyVersionsDirName+"/"+versionId+".json",
+				cloudTrailEvt.RequestParameters.PolicyDocument)
+			if cloudTrailEvt.RequestParameters.SetAsDefault {
+				written = writeFile(gitWorktree, policyDir+"/"+DefaultPolicyVersionFileName, []byte(versionId)) ||
+					written
+			}
+			validEvent = response.add(written || migrated)
+		case "CreateRole":
+			roleDir := RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
+			written := writePolicyDocument(gitWorktree, roleDir+"/"+AssumeRolePolicyDocumentFileName,
+				cloudTrailEvt.RequestParameters.AssumeRolePolicyDocument)
+			if cloudTrailEvt.RequestParameters.PermissionsBoundary != "" {
+				written = writeFile(gitWorktree, roleDir+"/"+PermissionsBoundaryFileName,
+					[]byte(cloudTrailEvt.RequestParameters.PermissionsBoundary)) || written
+			}
+			if len(cloudTrailEvt.RequestParameters.Tags) > 0 {
+				written = updateTags(gitWorktree, roleDir+"/"+TagsFileName, cloudTrailEvt.RequestParameters.Tags, nil) ||
+					written
+			}
+			role := cloudTrailEvt.ResponseElements.Role
+			role.RoleName = cloudTrailEvt.RequestParameters.RoleName
+			if role.Path == "" {
+				role.Path = cloudTrailEvt.RequestParameters.Path
+			}
+			if role.Description == "" {
+				role.Description = cloudTrailEvt.RequestParameters.Description
+			}
+			if role.MaxSessionDuration == 0 {
+				role.MaxSessionDuration = cloudTrailEvt.RequestParameters.MaxSessionDuration
+			}
+			var previousRole cloudtrail.Role
+			readJSON(roleDir+"/"+RoleFileName, &previousRole)
+			trailers = recordCreation(gitWorktree, HistoryDirName+"/"+roleDir+".json", roleDir, previousRole.RoleID,
+				incarnation{ID: role.RoleID, Arn: role.Arn, CreatedAt: cloudTrailEvt.EventTime})
+			written = writeJSON(gitWorktree, roleDir+"/"+RoleFileName, role) || written
+			validEvent = response.add(written)
+		case "CreateSAMLProvider":
+			samlProviderName := cloudTrailEvt.RequestParameters.Name
+			if cloudTrailEvt.ResponseElements.SAMLProviderArn != "" {
+				samlProviderName = cloudtrail.IdentityProviderName(cloudTrailEvt.ResponseElements.SAMLProviderArn)
+			}
+			samlProviderDir := SAMLProvidersDirName + "/" + samlProviderName
+			validEvent = response.add(writeFile(gitWorktree, samlProviderDir+"/"+SAMLMetadataFileName,
+				[]byte(cloudTrailEvt.RequestParameters.SAMLMetadataDocument)))
+		case "CreateUser":
+			userDir := UsersDirName + "/" + cloudTrailEvt.RequestParameters.UserName
+			user := cloudTrailEvt.ResponseElements.User
+			if user.UserName == "" {
+				user.UserName = cloudTrailEvt.RequestParameters.UserName
+				user.Path = cloudTrailEvt.RequestParameters.Path
 			}
+			var previousUser cloudtrail.User
+			readJSON(userDir+"/"+UserFileName, &previousUser)
+			trailers = recordCreation(gitWorktree, HistoryDirName+"/"+userDir+".json", userDir, previousUser.UserID,
+				incarnation{ID: user.UserID, Arn: user.Arn, CreatedAt: cloudTrailEvt.EventTime})
+			written := writeJSON(gitWorktree, userDir+"/"+UserFileName, user)
+			if cloudTrailEvt.RequestParameters.PermissionsBoundary != "" {
+				written = writeFile(gitWorktree, userDir+"/"+PermissionsBoundaryFileName,
+					[]byte(cloudTrailEvt.RequestParameters.PermissionsBoundary)) || written
+			}
+			if len(cloudTrailEvt.RequestParameters.Tags) > 0 {
+				written = updateTags(gitWorktree, userDir+"/"+TagsFileName, cloudTrailEvt.RequestParameters.Tags, nil) ||
+					written
+			}
+			validEvent = response.add(written)
+		case "DeactivateMFADevice":
+			userDir := UsersDirName + "/" + requestUserName(&cloudTrailEvt)
+			mfaDeviceFile := userDir + "/" + MFADevicesDirName + "/" +
+				path.Base(cloudTrailEvt.RequestParameters.SerialNumber) + ".json"
+			validEvent = response.remove(removeFile(gitWorktree, mfaDeviceFile))
+		case "DeleteAccessKey":
+			userDir := UsersDirName + "/" + requestUserName(&cloudTrailEvt)
+			accessKeyFile := userDir + "/" + AccessKeysDirName + "/" + cloudTrailEvt.RequestParameters.AccessKeyID +
+				".json"
+			validEvent = response.remove(removeFile(gitWorktree, accessKeyFile))
+		case "DeleteAccountAlias":
+			validEvent = response.remove(removeFile(gitWorktree, AccountDirName+"/"+AccountAliasFileName))
+		case "DeleteAccountPasswordPolicy":
+			validEvent = response.remove(removeFile(gitWorktree, AccountDirName+"/"+PasswordPolicyFileName))
+		case "DeleteGroup":
+			groupDir := GroupsDirName + "/" + cloudTrailEvt.RequestParameters.GroupName
+			validEvent = response.remove(removeFile(gitWorktree, groupDir))
+		case "DeleteGroupPolicy":
+			groupDir := GroupsDirName + "/" + cloudTrailEvt.RequestParameters.GroupName
+			inlinePolicyFile := groupDir + "/" + InlinePoliciesDirName + "/" + cloudTrailEvt.RequestParameters.PolicyName
+			validEvent = response.remove(removeFile(gitWorktree, inlinePolicyFile))
+		case "DeleteInstanceProfile":
+			instanceProfileDir := InstanceProfilesDirName + "/" + cloudTrailEvt.RequestParameters.InstanceProfileName
+			validEvent = response.remove(removeFile(gitWorktree, instanceProfileDir))
+		case "DeleteLoginProfile":
+			userDir := UsersDirName + "/" + requestUserName(&cloudTrailEvt)
+			validEvent = response.remove(removeFile(gitWorktree, userDir+"/"+LoginProfileFileName))
+		case "DeleteOpenIDConnectProvider":
+			oidcProviderDir := OpenIDConnectProvidersDirName + "/" +
+				cloudtrail.IdentityProviderName(cloudTrailEvt.RequestParameters.OpenIDConnectProviderArn)
+			validEvent = response.remove(removeFile(gitWorktree, oidcProviderDir))
 		case "DeletePolicy":
-			policyName := parsePolicyName(cloudTrailEvt.RequestParameters.PolicyArn)
-			utils.CheckError(err, "msg=\"Error parsing policy arn\" err=\"%s\"")
-			policyFile := os.TempDir() + "/" + PoliciesDirName + "/" + policyName
-			_, err = gitWorktree.Remove(policyFile)
-			utils.CheckError(err, "msg=\"Error removing policy file from Git work tree\" err=\"%s\"")
-			log.Printf("msg=\"Git Remove\" file=\"%s\"", policyFile)
-			response.Removed++
+			policyDir := PoliciesDirName + "/" + requestPolicyName(&cloudTrailEvt.RequestParameters)
+			validEvent = response.remove(removeFile(gitWorktree, policyDir))
+		case "DeletePolicyVersion":
+			policyDir := PoliciesDirName + "/" + requestPolicyName(&cloudTrailEvt.RequestParameters)
+			migrated := migratePolicyFile(gitWorktree, policyDir, PolicyVersionsDirName, DefaultPolicyVersionFileName,
+				LegacyPolicyVersionId)
+			versionFile := policyDir + "/" + PolicyVersionsDirName + "/" + cloudTrailEvt.RequestParameters.VersionId +
+				".json"
+			validEvent = response.remove(removeFile(gitWorktree, versionFile) || migrated)
 		case "DeleteRole":
-			roleDir := os.TempDir() + "/" + RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
-			_, err = gitWorktree.Remove(roleDir)
-			utils.CheckError(err, "msg=\"Error removing role directory from Git work tree\" err=\"%s\"")
-			log.Printf("msg=\"Git Remove\" dir=\"%s\"", roleDir)
-			response.Removed++
+			roleDir := RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
+			var role cloudtrail.Role
+			if readJSON(roleDir+"/"+RoleFileName, &role) && role.RoleID != "" {
+				recordIncarnation(gitWorktree, HistoryDirName+"/"+roleDir+".json",
+					incarnation{ID: role.RoleID, Arn: role.Arn, DeletedAt: cloudTrailEvt.EventTime})
+			}
+			validEvent = response.remove(removeFile(gitWorktree, roleDir))
+		case "DeleteRolePermissionsBoundary":
+			roleDir := RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
+			validEvent = response.remove(removeFile(gitWorktree, roleDir+"/"+PermissionsBoundaryFileName))
 		case "DeleteRolePolicy":
-			roleDir := os.TempDir() + "/" + RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
-			inlinePolicyFile := os.TempDir() + "/" + roleDir + "/_inline"
-			_, err = gitWorktree.Remove(inlinePolicyFile)
-			utils.CheckError(err, "msg=\"Error removing inline policy from Git work tree\" err=\"%s\"")
-			log.Printf("msg=\"Git Remove\" file=\"%s\"", inlinePolicyFile)
-			response.Removed++
+			roleDir := RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
+			inlinePolicyFile := roleDir + "/" + InlinePoliciesDirName + "/" + cloudTrailEvt.RequestParameters.PolicyName
+			validEvent = response.remove(removeFile(gitWorktree, inlinePolicyFile))
+		case "DeleteSAMLProvider":
+			samlProviderDir := SAMLProvidersDirName + "/" +
+				cloudtrail.IdentityProviderName(cloudTrailEvt.RequestParameters.SAMLProviderArn)
+			validEvent = response.remove(removeFile(gitWorktree, samlProviderDir))
+		case "DeleteUser":
+			userDir := UsersDirName + "/" + cloudTrailEvt.RequestParameters.UserName
+			var user cloudtrail.User
+			if readJSON(userDir+"/"+UserFileName, &user) && user.UserID != "" {
+				recordIncarnation(gitWorktree, HistoryDirName+"/"+userDir+".json",
+					incarnation{ID: user.UserID, Arn: user.Arn, DeletedAt: cloudTrailEvt.EventTime})
+			}
+			validEvent = response.remove(removeFile(gitWorktree, userDir))
+		case "DeleteUserPermissionsBoundary":
+			userDir := UsersDirName + "/" + cloudTrailEvt.RequestParameters.UserName
+			validEvent = response.remove(removeFile(gitWorktree, userDir+"/"+PermissionsBoundaryFileName))
+		case "DeleteUserPolicy":
+			userDir := UsersDirName + "/" + cloudTrailEvt.RequestParameters.UserName
+			inlinePolicyFile := userDir + "/" + InlinePoliciesDirName + "/" + cloudTrailEvt.RequestParameters.PolicyName
+			validEvent = response.remove(removeFile(gitWorktree, inlinePolicyFile))
+		case "DetachGroupPolicy":
+			groupDir := GroupsDirName + "/" + cloudTrailEvt.RequestParameters.GroupName
+			policyName := parsePolicyName(cloudTrailEvt.RequestParameters.PolicyArn)
+			attachedPolicyFile := groupDir + "/" + AttachedPoliciesDirName + "/" + policyName
+			validEvent = response.remove(removeFile(gitWorktree, attachedPolicyFile))
 		case "DetachRolePolicy":
-			roleDir := os.TempDir() + "/" + RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
-			attachedPolicyFile := os.TempDir() + "/" + roleDir + "/" + AttachedPoliciesDirName + "/" +
-				cloudTrailEvt.RequestParameters.PolicyName
-			_, err = gitWorktree.Remove(attachedPolicyFile)
-			utils.CheckError(err, "msg=\"Error removing attached policy from Git work tree\" err=\"%s\"")
-			response.Removed++
+			roleDir := RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
+			policyName := parsePolicyName(cloudTrailEvt.RequestParameters.PolicyArn)
+			attachedPolicyFile := roleDir + "/" + AttachedPoliciesDirName + "/" + policyName
+			validEvent = response.remove(removeFile(gitWorktree, attachedPolicyFile))
+		case "DetachUserPolicy":
+			userDir := UsersDirName + "/" + cloudTrailEvt.RequestParameters.UserName
+			policyName := parsePolicyName(cloudTrailEvt.RequestParameters.PolicyArn)
+			attachedPolicyFile := userDir + "/" + AttachedPoliciesDirName + "/" + policyName
+			validEvent = response.remove(removeFile(gitWorktree, attachedPolicyFile))
+		case "EnableMFADevice":
+			userDir := UsersDirName + "/" + requestUserName(&cloudTrailEvt)
+			mfaDevice := cloudtrail.MFADevice{
+				EnableDate:   cloudTrailEvt.EventTime,
+				SerialNumber: cloudTrailEvt.RequestParameters.SerialNumber,
+				UserName:     requestUserName(&cloudTrailEvt),
+			}
+			validEvent = response.add(writeJSON(gitWorktree,
+				userDir+"/"+MFADevicesDirName+"/"+path.Base(mfaDevice.SerialNumber)+".json", mfaDevice))
+		case "PutGroupPolicy":
+			groupDir := GroupsDirName + "/" + cloudTrailEvt.RequestParameters.GroupName
+			inlinePolicyFile := groupDir + "/" + InlinePoliciesDirName + "/" + cloudTrailEvt.RequestParameters.PolicyName
+			validEvent = response.add(writePolicyDocument(gitWorktree, inlinePolicyFile,
+				cloudTrailEvt.RequestParameters.PolicyDocument))
+		case "PutRolePermissionsBoundary":
+			roleDir := RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
+			validEvent = response.add(writeFile(gitWorktree, roleDir+"/"+PermissionsBoundaryFileName,
+				[]byte(cloudTrailEvt.RequestParameters.PermissionsBoundary)))
 		case "PutRolePolicy":
-			roleDir := os.TempDir() + "/" + RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
-			inlinePolicyFile := roleDir + "/_inline"
-			inlinePolicyFileHandle, err := os.OpenFile(inlinePolicyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-			utils.CheckError(err, "msg=\"Error opening inline policy file\" err=\"%s\"")
-			_, err = inlinePolicyFileHandle.WriteString(cloudTrailEvt.RequestParameters.PolicyArn)
-			utils.CheckError(err, "msg=\"Error appending inline policy file\" err=\"%s\"")
-			err = inlinePolicyFileHandle.Close()
-			utils.CheckError(err, "msg=\"Error closing inline policy file\" err=\"%s\"")
-			_, err = gitWorktree.Add(inlinePolicyFile)
-			utils.CheckError(err, "msg=\"Error adding inline policy file to Git work tree\" err=\"%s\"")
-			log.Printf("msg=\"Git Add\" file=\"%s\"", inlinePolicyFile)
-			response.Added++
+			roleDir := RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
+			inlinePolicyFile := roleDir + "/" + InlinePoliciesDirName + "/" + cloudTrailEvt.RequestParameters.PolicyName
+			validEvent = response.add(writePolicyDocument(gitWorktree, inlinePolicyFile,
+				cloudTrailEvt.RequestParameters.PolicyDocument))
+		case "PutUserPermissionsBoundary":
+			userDir := UsersDirName + "/" + cloudTrailEvt.RequestParameters.UserName
+			validEvent = response.add(writeFile(gitWorktree, userDir+"/"+PermissionsBoundaryFileName,
+				[]byte(cloudTrailEvt.RequestParameters.PermissionsBoundary)))
+		case "PutUserPolicy":
+			userDir := UsersDirName + "/" + cloudTrailEvt.RequestParameters.UserName
+			inlinePolicyFile := userDir + "/" + InlinePoliciesDirName + "/" + cloudTrailEvt.RequestParameters.PolicyName
+			validEvent = response.add(writePolicyDocument(gitWorktree, inlinePolicyFile,
+				cloudTrailEvt.RequestParameters.PolicyDocument))
+		case "RemoveClientIDFromOpenIDConnectProvider":
+			oidcProviderDir := OpenIDConnectProvidersDirName + "/" +
+				cloudtrail.IdentityProviderName(cloudTrailEvt.RequestParameters.OpenIDConnectProviderArn)
+			validEvent = response.remove(updateList(gitWorktree, oidcProviderDir+"/"+ClientIDsFileName,
+				cloudTrailEvt.RequestParameters.ClientID, false))
+		case "RemoveRoleFromInstanceProfile":
+			instanceProfileName := cloudTrailEvt.RequestParameters.InstanceProfileName
+			roleName := cloudTrailEvt.RequestParameters.RoleName
+			removed := updateList(gitWorktree, InstanceProfilesDirName+"/"+instanceProfileName+"/"+RolesFileName,
+				roleName, false)
+			removed = updateList(gitWorktree, RolesDirName+"/"+roleName+"/"+InstanceProfilesFileName,
+				instanceProfileName, false) || removed
+			validEvent = response.remove(removed)
+		case "RemoveUserFromGroup":
+			membersFile := GroupsDirName + "/" + cloudTrailEvt.RequestParameters.GroupName + "/" + MembersFileName
+			validEvent = response.remove(updateList(gitWorktree, membersFile,
+				cloudTrailEvt.RequestParameters.UserName, false))
 		case "SetDefaultPolicyVersion":
-			policyVersionOutput, err := iamSvc.GetPolicyVersion(&iam.GetPolicyVersionInput{
-				PolicyArn: aws.String(cloudTrailEvt.RequestParameters.PolicyArn),
-				VersionId: aws.String(cloudTrailEvt.RequestParameters.VersionId),
-			})
-			utils.CheckError(err, "msg=\"Error getting policy version output\" err=\"%s\"")
-			policyName := parsePolicyName(cloudTrailEvt.RequestParameters.PolicyName)
-			policyFile := os.TempDir() + "/" + PoliciesDirName + "/" + policyName
-			policyFileHandle, err := os.OpenFile(policyFile, os.O_WRONLY, 0644)
-			utils.CheckError(err, "msg=\"Error opening policy file\" err=\"%s\"")
-			err = policyFileHandle.Truncate(0)
-			utils.CheckError(err, "msg=\"Error truncating policy file\" err=\"%s\"")
-			_, err = policyFileHandle.Seek(0, 0)
-			utils.CheckError(err, "msg=\"Error seeking policy file\" err=\"%s\"")
-			_, err = policyFileHandle.WriteString(*policyVersionOutput.PolicyVersion.Document)
-			utils.CheckError(err, "msg=\"Error writing policy file\" err=\"%s\"")
-			_, err = gitWorktree.Add(policyFile)
-			utils.CheckError(err, "msg=\"Error adding policy file to Git work tree\" err=\"%s\"")
-			log.Printf("msg=\"Git Add\" file=\"%s\"", policyFile)
-			response.Added++
+			policyDir := PoliciesDirName + "/" + requestPolicyName(&cloudTrailEvt.RequestParameters)
+			versionId := cloudTrailEvt.RequestParameters.VersionId
+			versionFile := policyDir + "/" + PolicyVersionsDirName + "/" + versionId + ".json"
+			written := migratePolicyFile(gitWorktree, policyDir, PolicyVersionsDirName, DefaultPolicyVersionFileName,
+				LegacyPolicyVersionId)
+			if _, err := os.Stat(worktreePath(versionFile)); os.IsNotExist(err) {
+				// The version was created before the policy was audited, so get its document from IAM.
+				policyVersionOutput, err := iamSvc.GetPolicyVersion(&iam.GetPolicyVersionInput{
+					PolicyArn: aws.String(cloudTrailEvt.RequestParameters.PolicyArn),
+					VersionId: aws.String(versionId),
+				})
+				utils.CheckError(err, "msg=\"Error getting policy version output\" err=\"%s\"")
+				policyDocument, err := url.QueryUnescape(aws.StringValue(policyVersionOutput.PolicyVersion.Document))
+				utils.CheckError(err, "msg=\"Error decoding policy version document\" err=\"%s\"")
+				written = writePolicyDocument(gitWorktree, versionFile, policyDocument) || written
+			}
+			written = writeFile(gitWorktree, policyDir+"/"+DefaultPolicyVersionFileName, []byte(versionId)) || written
+			validEvent = response.add(written)
+		case "SetSecurityTokenServicePreferences":
+			validEvent = response.add(writeJSON(gitWorktree, AccountDirName+"/"+STSPreferencesFileName, map[string]string{
+				"globalEndpointTokenVersion": cloudTrailEvt.RequestParameters.GlobalEndpointTokenVersion,
+			}))
+		case "TagPolicy":
+			policyDir := PoliciesDirName + "/" + requestPolicyName(&cloudTrailEvt.RequestParameters)
+			migrated := migratePolicyFile(gitWorktree, policyDir, PolicyVersionsDirName, DefaultPolicyVersionFileName,
+				LegacyPolicyVersionId)
+			validEvent = response.add(updateTags(gitWorktree, policyDir+"/"+TagsFileName,
+				cloudTrailEvt.RequestParameters.Tags, nil) || migrated)
+		case "TagRole":
+			roleDir := RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
+			validEvent = response.add(updateTags(gitWorktree, roleDir+"/"+TagsFileName,
+				cloudTrailEvt.RequestParameters.Tags, nil))
+		case "TagUser":
+			userDir := UsersDirName + "/" + cloudTrailEvt.RequestParameters.UserName
+			validEvent = response.add(updateTags(gitWorktree, userDir+"/"+TagsFileName,
+				cloudTrailEvt.RequestParameters.Tags, nil))
+		case "UntagPolicy":
+			policyDir := PoliciesDirName + "/" + requestPolicyName(&cloudTrailEvt.RequestParameters)
+			migrated := migratePolicyFile(gitWorktree, policyDir, PolicyVersionsDirName, DefaultPolicyVersionFileName,
+				LegacyPolicyVersionId)
+			validEvent = response.remove(updateTags(gitWorktree, policyDir+"/"+TagsFileName,
+				nil, cloudTrailEvt.RequestParameters.TagKeys) || migrated)
+		case "UntagRole":
+			roleDir := RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
+			validEvent = response.remove(updateTags(gitWorktree, roleDir+"/"+TagsFileName,
+				nil, cloudTrailEvt.RequestParameters.TagKeys))
+		case "UntagUser":
+			userDir := UsersDirName + "/" + cloudTrailEvt.RequestParameters.UserName
+			validEvent = response.remove(updateTags(gitWorktree, userDir+"/"+TagsFileName,
+				nil, cloudTrailEvt.RequestParameters.TagKeys))
+		case "UpdateAccessKey":
+			userDir := UsersDirName + "/" + requestUserName(&cloudTrailEvt)
+			accessKeyFile := userDir + "/" + AccessKeysDirName + "/" + cloudTrailEvt.RequestParameters.AccessKeyID +
+				".json"
+			var accessKey cloudtrail.AccessKey
+			readJSON(accessKeyFile, &accessKey)
+			accessKey.AccessKeyID = cloudTrailEvt.RequestParameters.AccessKeyID
+			accessKey.Status = cloudTrailEvt.RequestParameters.Status
+			accessKey.UserName = requestUserName(&cloudTrailEvt)
+			validEvent = response.add(writeJSON(gitWorktree, accessKeyFile, accessKey))
+		case "UpdateAccountPasswordPolicy":
+			// The policy is replaced as a whole, with unspecified settings taking their defaults.
+			passwordPolicy := cloudtrail.PasswordPolicy{
+				AllowUsersToChangePassword: cloudTrailEvt.RequestParameters.AllowUsersToChangePassword,
+				HardExpiry:                 cloudTrailEvt.RequestParameters.HardExpiry,
+				MaxPasswordAge:             cloudTrailEvt.RequestParameters.MaxPasswordAge,
+				MinimumPasswordLength:      cloudTrailEvt.RequestParameters.MinimumPasswordLength,
+				PasswordReusePrevention:    cloudTrailEvt.RequestParameters.PasswordReusePrevention,
+				RequireLowercaseCharacters: cloudTrailEvt.RequestParameters.RequireLowercaseCharacters,
+				RequireNumbers:             cloudTrailEvt.RequestParameters.RequireNumbers,
+				RequireSymbols:             cloudTrailEvt.RequestParameters.RequireSymbols,
+				RequireUppercaseCharacters: cloudTrailEvt.RequestParameters.RequireUppercaseCharacters,
+			}
+			if passwordPolicy.MinimumPasswordLength == 0 {
+				passwordPolicy.MinimumPasswordLength = DefaultMinimumPasswordLength
+			}
+			validEvent = response.add(writeJSON(gitWorktree, AccountDirName+"/"+PasswordPolicyFileName, passwordPolicy))
+		case "UpdateAssumeRolePolicy":
+			roleDir := RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
+			validEvent = response.add(writePolicyDocument(gitWorktree, roleDir+"/"+AssumeRolePolicyDocumentFileName,
+				cloudTrailEvt.RequestParameters.PolicyDocument))
+		case "UpdateOpenIDConnectProviderThumbprint":
+			oidcProviderDir := OpenIDConnectProvidersDirName + "/" +
+				cloudtrail.IdentityProviderName(cloudTrailEvt.RequestParameters.OpenIDConnectProviderArn)
+			validEvent = response.add(writeJSON(gitWorktree, oidcProviderDir+"/"+ThumbprintsFileName,
+				sortedList(cloudTrailEvt.RequestParameters.ThumbprintList)))
+		case "UpdateRole":
+			roleDir := RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
+			role := cloudTrailEvt.ResponseElements.Role
+			readJSON(roleDir+"/"+RoleFileName, &role)
+			role.RoleName = cloudTrailEvt.RequestParameters.RoleName
+			if cloudTrailEvt.RequestParameters.Description != "" {
+				role.Description = cloudTrailEvt.RequestParameters.Description
+			}
+			if cloudTrailEvt.RequestParameters.MaxSessionDuration != 0 {
+				role.MaxSessionDuration = cloudTrailEvt.RequestParameters.MaxSessionDuration
+			}
+			validEvent = response.add(writeJSON(gitWorktree, roleDir+"/"+RoleFileName, role))
+		case "UpdateRoleDescription":
+			roleDir := RolesDirName + "/" + cloudTrailEvt.RequestParameters.RoleName
+			role := cloudTrailEvt.ResponseElements.Role
+			readJSON(roleDir+"/"+RoleFileName, &role)
+			role.RoleName = cloudTrailEvt.RequestParameters.RoleName
+			role.Description = cloudTrailEvt.RequestParameters.Description
+			validEvent = response.add(writeJSON(gitWorktree, roleDir+"/"+RoleFileName, role))
+		case "UpdateSAMLProvider":
+			samlProviderDir := SAMLProvidersDirName + "/" +
+				cloudtrail.IdentityProviderName(cloudTrailEvt.RequestParameters.SAMLProviderArn)
+			validEvent = response.add(writeFile(gitWorktree, samlProviderDir+"/"+SAMLMetadataFileName,
+				[]byte(cloudTrailEvt.RequestParameters.SAMLMetadataDocument)))
+		case "kms:PutKeyPolicy":
+			keyPolicyFile := ResourcePoliciesDirName + "/kms/" + path.Base(cloudTrailEvt.RequestParameters.KeyID) + ".json"
+			validEvent = response.add(writePolicyDocument(gitWorktree, keyPolicyFile,
+				cloudTrailEvt.RequestParameters.Policy))
+		case "lambda:AddPermission":
+			functionPolicyFile := regionalPolicyFile(ResourcePoliciesDirName+"/lambda", &cloudTrailEvt,
+				cloudTrailEvt.RequestParameters.FunctionName,
+				cloudtrail.FunctionName(cloudTrailEvt.RequestParameters.FunctionName))
+			validEvent = response.add(updateStatement(gitWorktree, functionPolicyFile,
+				cloudTrailEvt.RequestParameters.StatementID, permissionStatement(&cloudTrailEvt)))
+		case "lambda:RemovePermission":
+			functionPolicyFile := regionalPolicyFile(ResourcePoliciesDirName+"/lambda", &cloudTrailEvt,
+				cloudTrailEvt.RequestParameters.FunctionName,
+				cloudtrail.FunctionName(cloudTrailEvt.RequestParameters.FunctionName))
+			validEvent = response.remove(updateStatement(gitWorktree, functionPolicyFile,
+				cloudTrailEvt.RequestParameters.StatementID, nil))
+		case "organizations:AttachPolicy":
+			organizationsPolicyDir := OrganizationsPoliciesDirName + "/" + cloudTrailEvt.RequestParameters.PolicyId
+			targetDir := OrganizationsTargetsDirName + "/" + cloudTrailEvt.RequestParameters.TargetId
+			written := updateList(gitWorktree, organizationsPolicyDir+"/"+TargetsFileName,
+				cloudTrailEvt.RequestParameters.TargetId, true)
+			written = updateList(gitWorktree, targetDir+"/"+PoliciesFileName, cloudTrailEvt.RequestParameters.PolicyId,
+				true) || written
+			validEvent = response.add(written)
+		case "organizations:CreatePolicy":
+			policySummary := cloudTrailEvt.ResponseElements.Policy.PolicySummary
+			if policySummary.ID == "" {
+				log.Printf("msg=\"No policy in response\" name=\"%s\"", cloudTrailEvt.RequestParameters.Name)
+				validEvent = false
+				response.Ignored++
+
+				break
+			}
+			organizationsPolicyDir := OrganizationsPoliciesDirName + "/" + policySummary.ID
+			content := cloudTrailEvt.ResponseElements.Policy.Content
+			if content == "" {
+				content = cloudTrailEvt.RequestParameters.Content
+			}
+			written := writeJSON(gitWorktree, organizationsPolicyDir+"/"+OrganizationsPolicyFileName, policySummary)
+			written = writePolicyDocument(gitWorktree, organizationsPolicyDir+"/"+ContentFileName, content) || written
+			written = writeJSON(gitWorktree, organizationsPolicyDir+"/"+TargetsFileName, []string{}) || written
+			validEvent = response.add(written)
+		case "organizations:DeletePolicy":
+			organizationsPolicyDir := OrganizationsPoliciesDirName + "/" + cloudTrailEvt.RequestParameters.PolicyId
+			validEvent = response.remove(removeFile(gitWorktree, organizationsPolicyDir))
+		case "organizations:DetachPolicy":
+			organizationsPolicyDir := OrganizationsPoliciesDirName + "/" + cloudTrailEvt.RequestParameters.PolicyId
+			targetDir := OrganizationsTargetsDirName + "/" + cloudTrailEvt.RequestParameters.TargetId
+			removed := updateList(gitWorktree, organizationsPolicyDir+"/"+TargetsFileName,
+				cloudTrailEvt.RequestParameters.TargetId, false)
+			removed = updateList(gitWorktree, targetDir+"/"+PoliciesFileName, cloudTrailEvt.RequestParameters.PolicyId,
+				false) || removed
+			validEvent = response.remove(removed)
+		case "organizations:UpdatePolicy":
+			organizationsPolicyDir := OrganizationsPoliciesDirName + "/" + cloudTrailEvt.RequestParameters.PolicyId
+			policySummary := cloudTrailEvt.ResponseElements.Policy.PolicySummary
+			if policySummary.ID == "" {
+				readJSON(organizationsPolicyDir+"/"+OrganizationsPolicyFileName, &policySummary)
+				policySummary.ID = cloudTrailEvt.RequestParameters.PolicyId
+				if cloudTrailEvt.RequestParameters.Name != "" {
+					policySummary.Name = cloudTrailEvt.RequestParameters.Name
+				}
+				if cloudTrailEvt.RequestParameters.Description != "" {
+					policySummary.Description = cloudTrailEvt.RequestParameters.Description
+				}
+			}
+			written := writeJSON(gitWorktree, organizationsPolicyDir+"/"+OrganizationsPolicyFileName, policySummary)
+			content := cloudTrailEvt.ResponseElements.Policy.Content
+			if content == "" {
+				content = cloudTrailEvt.RequestParameters.Content
+			}
+			if content != "" {
+				written = writePolicyDocument(gitWorktree, organizationsPolicyDir+"/"+ContentFileName, content) ||
+					written
+			}
+			validEvent = response.add(written)
+		case "s3:DeleteBucketPolicy":
+			bucketPolicyFile := ResourcePoliciesDirName + "/s3/" + cloudTrailEvt.RequestParameters.BucketName + ".json"
+			validEvent = response.remove(removeFile(gitWorktree, bucketPolicyFile))
+		case "s3:PutBucketPolicy":
+			bucketPolicyFile := ResourcePoliciesDirName + "/s3/" + cloudTrailEvt.RequestParameters.BucketName + ".json"
+			validEvent = response.add(writePolicyDocument(gitWorktree, bucketPolicyFile,
+				string(cloudTrailEvt.RequestParameters.BucketPolicy)))
+		case "sns:SetTopicAttributes":
+			if cloudTrailEvt.RequestParameters.AttributeName != "Policy" {
+				validEvent = false
+				response.Ignored++
+
+				break
+			}
+			topicArn := cloudTrailEvt.RequestParameters.TopicArn
+			topicPolicyFile := regionalPolicyFile(ResourcePoliciesDirName+"/sns", &cloudTrailEvt, topicArn,
+				topicArn[strings.LastIndex(topicArn, ":")+1:])
+			validEvent = setResourcePolicy(gitWorktree, response, topicPolicyFile,
+				cloudTrailEvt.RequestParameters.AttributeValue)
+		case "sqs:SetQueueAttributes":
+			queuePolicy, ok := cloudTrailEvt.RequestParameters.Attributes["Policy"]
+			if !ok {
+				validEvent = false
+				response.Ignored++
+
+				break
+			}
+			queuePolicyFile := regionalPolicyFile(ResourcePoliciesDirName+"/sqs", &cloudTrailEvt, "",
+				path.Base(cloudTrailEvt.RequestParameters.QueueURL))
+			validEvent = setResourcePolicy(gitWorktree, response, queuePolicyFile, queuePolicy)
+		case "sso:AttachManagedPolicyToPermissionSet":
+			permissionSetDir := PermissionSetsDirName + "/" + permissionSetName(PermissionSetsDirName,
+				PermissionSetFileName, cloudTrailEvt.RequestParameters.PermissionSetArn)
+			validEvent = response.add(updateList(gitWorktree, permissionSetDir+"/"+ManagedPoliciesFileName,
+				cloudTrailEvt.RequestParameters.ManagedPolicyArn, true))
+		case "sso:CreateAccountAssignment":
+			permissionSetDir := PermissionSetsDirName + "/" + permissionSetName(PermissionSetsDirName,
+				PermissionSetFileName, cloudTrailEvt.RequestParameters.PermissionSetArn)
+			validEvent = response.add(updateList(gitWorktree, permissionSetDir+"/"+AssignmentsFileName,
+				accountAssignment(&cloudTrailEvt.RequestParameters), true))
+		case "sso:CreatePermissionSet":
+			permissionSet := cloudTrailEvt.ResponseElements.PermissionSet
+			if permissionSet.Name == "" {
+				permissionSet.Name = cloudTrailEvt.RequestParameters.Name
+				permissionSet.Description = cloudTrailEvt.RequestParameters.Description
+				permissionSet.RelayState = cloudTrailEvt.RequestParameters.RelayState
+				permissionSet.SessionDuration = cloudTrailEvt.RequestParameters.SessionDuration
+			}
+			permissionSetDir := PermissionSetsDirName + "/" + permissionSet.Name
+			written := writeJSON(gitWorktree, permissionSetDir+"/"+PermissionSetFileName, permissionSet)
+			written = writeJSON(gitWorktree, permissionSetDir+"/"+ManagedPoliciesFileName, []string{}) || written
+			written = writeJSON(gitWorktree, permissionSetDir+"/"+AssignmentsFileName, []string{}) || written
+			validEvent = response.add(written)
+		case "sso:DeleteAccountAssignment":
+			permissionSetDir := PermissionSetsDirName + "/" + permissionSetName(PermissionSetsDirName,
+				PermissionSetFileName, cloudTrailEvt.RequestParameters.PermissionSetArn)
+			validEvent = response.remove(updateList(gitWorktree, permissionSetDir+"/"+AssignmentsFileName,
+				accountAssignment(&cloudTrailEvt.RequestParameters), false))
+		case "sso:DeleteInlinePolicyFromPermissionSet":
+			permissionSetDir := PermissionSetsDirName + "/" + permissionSetName(PermissionSetsDirName,
+				PermissionSetFileName, cloudTrailEvt.RequestParameters.PermissionSetArn)
+			validEvent = response.remove(removeFile(gitWorktree, permissionSetDir+"/"+InlinePolicyFileName))
+		case "sso:DeletePermissionSet":
+			permissionSetDir := PermissionSetsDirName + "/" + permissionSetName(PermissionSetsDirName,
+				PermissionSetFileName, cloudTrailEvt.RequestParameters.PermissionSetArn)
+			validEvent = response.remove(removeFile(gitWorktree, permissionSetDir))
+		case "sso:DetachManagedPolicyFromPermissionSet":
+			permissionSetDir := PermissionSetsDirName + "/" + permissionSetName(PermissionSetsDirName,
+				PermissionSetFileName, cloudTrailEvt.RequestParameters.PermissionSetArn)
+			validEvent = response.remove(updateList(gitWorktree, permissionSetDir+"/"+ManagedPoliciesFileName,
+				cloudTrailEvt.RequestParameters.ManagedPolicyArn, false))
+		case "sso:ProvisionPermissionSet":
+			permissionSetDir := PermissionSetsDirName + "/" + permissionSetName(PermissionSetsDirName,
+				PermissionSetFileName, cloudTrailEvt.RequestParameters.PermissionSetArn)
+			validEvent = response.add(writeJSON(gitWorktree, permissionSetDir+"/"+ProvisioningFileName, map[string]string{
+				"provisionedAt": cloudTrailEvt.EventTime,
+				"targetId":      cloudTrailEvt.RequestParameters.TargetId,
+				"targetType":    cloudTrailEvt.RequestParameters.TargetType,
+			}))
+		case "sso:PutInlinePolicyToPermissionSet":
+			permissionSetDir := PermissionSetsDirName + "/" + permissionSetName(PermissionSetsDirName,
+				PermissionSetFileName, cloudTrailEvt.RequestParameters.PermissionSetArn)
+			validEvent = response.add(writePolicyDocument(gitWorktree, permissionSetDir+"/"+InlinePolicyFileName,
+				cloudTrailEvt.RequestParameters.InlinePolicy))
 		default:
 			validEvent = false
 			response.Ignored++
@@ -191,9 +954,9 @@ func Auditor(ctx context.Context, evt events.SQSEvent, gitAuth transport.AuthMet
 		if validEvent {
 			when, err := time.Parse(time.RFC3339, cloudTrailEvt.EventTime)
 			utils.CheckError(err, "msg=\"Error parsing time\" err=\"%s\"")
-			commit, err := gitWorktree.Commit(eventName+" by "+cloudTrailEvt.UserIdentity.UserName, &git.CommitOptions{
+			commit, err := gitWorktree.Commit(commitMessage(&cloudTrailEvt, evt.Records[i], trailers...), &git.CommitOptions{
 				Author: &object.Signature{
-					Name:  cloudTrailEvt.UserIdentity.UserName,
+					Name:  cloudTrailEvt.UserIdentity.Name(),
 					Email: "noreply@nowhere.com",
 					When:  when,
 				},
@@ -272,7 +1035,10 @@ func handler(ctx context.Context, evt events.SQSEvent) (*response, error) {
 	// Initialize the IAM service.
 	iamSvc := iam.New(sess)
 
-	return Auditor(ctx, evt, gitAuth, gitRepo, gitWorkTree, iamSvc)
+	// Initialize the S3 service.
+	s3Svc := s3.New(sess)
+
+	return Auditor(ctx, evt, gitAuth, gitRepo, gitWorkTree, iamSvc, s3Svc)
 }
 
 func main() {
@@ -1,17 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/dlabey/iam-git-auditor/pkg/cloudtrail"
+	"github.com/dlabey/iam-git-auditor/pkg/queue"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"gopkg.in/src-d/go-git.v4"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"io/ioutil"
+	"os"
 	"testing"
 )
 
@@ -71,8 +77,21 @@ func (m *MockIamSvc) GetPolicyVersion(input *iam.GetPolicyVersionInput) (*iam.Ge
 	return args.Get(0).(*iam.GetPolicyVersionOutput), args.Error(1)
 }
 
+type MockS3Svc struct {
+	s3.S3
+	mock.Mock
+}
+
+func (m *MockS3Svc) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	args := m.Called(input)
+
+	return args.Get(0).(*s3.GetObjectOutput), args.Error(1)
+}
+
 func TestAuditor(t *testing.T) {
 	ctx := new(context.Context)
+	_, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
 	cloudTrailEvt1 := cloudtrail.CloudTrailEvent{
 		EventName: "CreatePolicy",
 		RequestParameters: cloudtrail.RequestParameters{
@@ -112,6 +131,7 @@ func TestAuditor(t *testing.T) {
 	gitRepoMock := new(MockGitRepo)
 	gitWorktreeMock := new(MockGitWorktree)
 	iamSvcMock := new(MockIamSvc)
+	s3SvcMock := new(MockS3Svc)
 
 	gitWorktreeMock.On("Add", mock.AnythingOfType("string")).Return(plumbing.Hash{}, nil)
 	gitWorktreeMock.On("Commit", mock.AnythingOfType("string"),
@@ -124,9 +144,1419 @@ func TestAuditor(t *testing.T) {
 	iamSvcMock.On("GetPolicyVersion", mock.AnythingOfType("*iam.GetPolicyVersionInput")).Return(
 		&iam.GetPolicyVersionOutput{}, nil)
 
-	response, err := Auditor(*ctx, sqsEvt, gitAuth, gitRepoMock, gitWorktreeMock, iamSvcMock)
+	response, err := Auditor(*ctx, sqsEvt, gitAuth, gitRepoMock, gitWorktreeMock, iamSvcMock, s3SvcMock)
 	assert.Nil(t, err)
 	assert.Equal(t, 1, response.Added)
 	assert.Equal(t, 1, response.Removed)
 	assert.Equal(t, 1, response.Ignored)
 }
+
+func TestAuditorOverflow(t *testing.T) {
+	ctx := new(context.Context)
+	dir, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	cloudTrailEvt := cloudtrail.CloudTrailEvent{
+		EventID:   "eventID",
+		EventName: "CreatePolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			PolicyName:     "overflowPolicyName",
+			PolicyDocument: "policyDocument",
+		},
+		EventTime: "2012-11-01T22:08:41+00:00",
+	}
+	cloudTrailEvtJson, _ := json.Marshal(cloudTrailEvt)
+	pointerJson, _ := json.Marshal(cloudtrail.CloudTrailEvent{
+		EventID:   "eventID",
+		EventName: "CreatePolicy",
+		EventTime: "2012-11-01T22:08:41+00:00",
+	})
+	sqsEvt := events.SQSEvent{
+		Records: []events.SQSMessage{{
+			Body: string(pointerJson),
+			MessageAttributes: map[string]events.SQSMessageAttribute{
+				queue.OverflowBucketAttribute: {
+					DataType:    "String",
+					StringValue: aws.String("overflow"),
+				},
+				queue.OverflowKeyAttribute: {
+					DataType:    "String",
+					StringValue: aws.String("overflow/eventID.json"),
+				},
+			},
+		}},
+	}
+	gitAuth := &http.BasicAuth{}
+	gitRepoMock := new(MockGitRepo)
+	gitWorktreeMock := new(MockGitWorktree)
+	iamSvcMock := new(MockIamSvc)
+	s3SvcMock := new(MockS3Svc)
+
+	gitWorktreeMock.On("Add", mock.AnythingOfType("string")).Return(plumbing.Hash{}, nil)
+	gitWorktreeMock.On("Commit", mock.AnythingOfType("string"),
+		mock.AnythingOfType("*git.CommitOptions")).Return(plumbing.Hash{}, nil)
+
+	gitRepoMock.On("CommitObject", mock.AnythingOfType("plumbing.Hash")).Return(&object.Commit{}, nil)
+	gitRepoMock.On("Push", mock.AnythingOfType("*git.PushOptions")).Return(nil)
+
+	s3SvcMock.On("GetObject", &s3.GetObjectInput{
+		Bucket: aws.String("overflow"),
+		Key:    aws.String("overflow/eventID.json"),
+	}).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewBuffer(cloudTrailEvtJson)),
+	}, nil)
+
+	response, err := Auditor(*ctx, sqsEvt, gitAuth, gitRepoMock, gitWorktreeMock, iamSvcMock, s3SvcMock)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, response.Added)
+	policyDocument, err := ioutil.ReadFile(dir + "/policies/overflowPolicyName/versions/v1.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "policyDocument", string(policyDocument))
+}
+
+func TestResolveBody(t *testing.T) {
+	s3SvcMock := new(MockS3Svc)
+
+	// A message without overflow attributes carries the CloudTrail event itself.
+	body, err := resolveBody(events.SQSMessage{Body: "{}"}, s3SvcMock)
+	assert.Nil(t, err)
+	assert.Equal(t, "{}", string(body))
+
+	// A message with only one of the overflow attributes cannot be resolved.
+	for _, attribute := range []string{queue.OverflowBucketAttribute, queue.OverflowKeyAttribute} {
+		_, err = resolveBody(events.SQSMessage{
+			Body: "{}",
+			MessageAttributes: map[string]events.SQSMessageAttribute{
+				attribute: {
+					DataType:    "String",
+					StringValue: aws.String("overflow"),
+				},
+			},
+		}, s3SvcMock)
+		assert.NotNil(t, err)
+	}
+	s3SvcMock.AssertNotCalled(t, "GetObject", mock.Anything)
+}
+
+func TestCommitMessage(t *testing.T) {
+	cloudTrailEvt := cloudtrail.CloudTrailEvent{
+		EventName: "CreatePolicy",
+		UserIdentity: cloudtrail.UserIdentity{
+			UserName: "userName",
+		},
+	}
+	assert.Equal(t, "CreatePolicy by userName", commitMessage(&cloudTrailEvt, events.SQSMessage{}))
+
+	sqsMsg := events.SQSMessage{
+		MessageAttributes: map[string]events.SQSMessageAttribute{
+			queue.LogFileValidationAttribute: {
+				DataType:    "String",
+				StringValue: aws.String(queue.LogFileVerified),
+			},
+		},
+	}
+	assert.Equal(t, "CreatePolicy by userName\n\nLog-File-Validation: verified", commitMessage(&cloudTrailEvt, sqsMsg))
+	assert.Equal(t, "CreatePolicy by userName\n\nRecreated: roles/roleName was AROAFIRST, now AROASECOND\n"+
+		"Log-File-Validation: verified", commitMessage(&cloudTrailEvt, sqsMsg,
+		"Recreated: roles/roleName was AROAFIRST, now AROASECOND"))
+
+	cloudTrailEvt.UserIdentity = cloudtrail.UserIdentity{
+		Type: "AssumedRole",
+		Arn:  "arn:aws:sts::123456789012:assumed-role/roleName/sessionName",
+	}
+	assert.Equal(t, "CreatePolicy by assumed-role/roleName/sessionName",
+		commitMessage(&cloudTrailEvt, events.SQSMessage{}))
+}
+
+// Points os.TempDir, where the Auditor keeps the Git work tree, at a directory of the test's own, returning the
+// directory and a func restoring os.TempDir and removing the directory.
+func useTempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "auditor")
+	assert.Nil(t, err)
+	tmpDir, tmpDirSet := os.LookupEnv("TMPDIR")
+	os.Setenv("TMPDIR", dir)
+
+	return dir, func() {
+		if tmpDirSet {
+			os.Setenv("TMPDIR", tmpDir)
+		} else {
+			os.Unsetenv("TMPDIR")
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// Audits the CloudTrail events into the Git work tree.
+func auditEvents(t *testing.T, cloudTrailEvts ...cloudtrail.CloudTrailEvent) (*response, *MockGitWorktree) {
+	var bodies []string
+	for _, cloudTrailEvt := range cloudTrailEvts {
+		cloudTrailEvtJson, _ := json.Marshal(cloudTrailEvt)
+		bodies = append(bodies, string(cloudTrailEvtJson))
+	}
+
+	return auditBodies(t, bodies...)
+}
+
+// Audits the SQS message bodies into the Git work tree.
+func auditBodies(t *testing.T, bodies ...string) (*response, *MockGitWorktree) {
+	ctx := new(context.Context)
+
+	sqsEvt := events.SQSEvent{}
+	for _, body := range bodies {
+		sqsEvt.Records = append(sqsEvt.Records, events.SQSMessage{
+			Body: body,
+		})
+	}
+	gitAuth := &http.BasicAuth{}
+	gitRepoMock := new(MockGitRepo)
+	gitWorktreeMock := new(MockGitWorktree)
+	iamSvcMock := new(MockIamSvc)
+	s3SvcMock := new(MockS3Svc)
+
+	gitWorktreeMock.On("Add", mock.AnythingOfType("string")).Return(plumbing.Hash{}, nil)
+	gitWorktreeMock.On("Commit", mock.AnythingOfType("string"),
+		mock.AnythingOfType("*git.CommitOptions")).Return(plumbing.Hash{}, nil)
+	gitWorktreeMock.On("Remove", mock.AnythingOfType("string")).Return(plumbing.Hash{}, nil)
+
+	gitRepoMock.On("CommitObject", mock.AnythingOfType("plumbing.Hash")).Return(&object.Commit{}, nil)
+	gitRepoMock.On("Push", mock.AnythingOfType("*git.PushOptions")).Return(nil)
+
+	iamSvcMock.On("GetPolicyVersion", mock.AnythingOfType("*iam.GetPolicyVersionInput")).Return(
+		&iam.GetPolicyVersionOutput{
+			PolicyVersion: &iam.PolicyVersion{
+				Document: aws.String("%7B%22Version%22%3A%222012-10-17%22%2C%22Statement%22%3A%5B%5D%7D"),
+			},
+		}, nil)
+
+	response, err := Auditor(*ctx, sqsEvt, gitAuth, gitRepoMock, gitWorktreeMock, iamSvcMock, s3SvcMock)
+	assert.Nil(t, err)
+
+	return response, gitWorktreeMock
+}
+
+// Reads a file of the Git work tree.
+func readFile(t *testing.T, dir string, name string) string {
+	content, err := ioutil.ReadFile(dir + "/" + name)
+	assert.Nil(t, err)
+
+	return string(content)
+}
+
+func TestAuditorUsers(t *testing.T) {
+	dir, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	response, gitWorktreeMock := auditEvents(t, cloudtrail.CloudTrailEvent{
+		EventName: "CreateUser",
+		RequestParameters: cloudtrail.RequestParameters{
+			UserName: "userName",
+		},
+		ResponseElements: cloudtrail.ResponseElements{
+			User: cloudtrail.User{
+				Arn:      "arn:aws:iam::123456789012:user/userName",
+				UserID:   "AIDAEXAMPLE",
+				UserName: "userName",
+			},
+		},
+		EventTime: "2012-11-01T22:08:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "AttachUserPolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			UserName:  "userName",
+			PolicyArn: "arn:aws:iam::aws:policy/ReadOnlyAccess",
+		},
+		EventTime: "2012-11-01T22:09:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "PutUserPolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			UserName:       "userName",
+			PolicyName:     "inlinePolicyName",
+			PolicyDocument: `{"Version":"2012-10-17","Statement":[]}`,
+		},
+		EventTime: "2012-11-01T22:10:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "DetachUserPolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			UserName:  "userName",
+			PolicyArn: "arn:aws:iam::aws:policy/ReadOnlyAccess",
+		},
+		EventTime: "2012-11-01T22:11:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "DeleteUserPolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			UserName:   "userName",
+			PolicyName: "inlinePolicyName",
+		},
+		EventTime: "2012-11-01T22:12:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "DeleteUser",
+		RequestParameters: cloudtrail.RequestParameters{
+			UserName: "userName",
+		},
+		EventTime: "2012-11-01T22:13:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "DeleteUserPolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			UserName:   "unknownUserName",
+			PolicyName: "inlinePolicyName",
+		},
+		EventTime: "2012-11-01T22:14:41Z",
+	})
+	assert.Equal(t, 3, response.Added)
+	assert.Equal(t, 3, response.Removed)
+	assert.Equal(t, 1, response.Ignored)
+	assert.Contains(t, readFile(t, dir, "users/userName/user.json"), `"userId": "AIDAEXAMPLE"`)
+	assert.Equal(t, "arn:aws:iam::aws:policy/ReadOnlyAccess",
+		readFile(t, dir, "users/userName/attachedPolicies/ReadOnlyAccess"))
+	assert.Equal(t, "{\n  \"Statement\": [],\n  \"Version\": \"2012-10-17\"\n}\n",
+		readFile(t, dir, "users/userName/inlinePolicies/inlinePolicyName"))
+	gitWorktreeMock.AssertCalled(t, "Remove", "users/userName/attachedPolicies/ReadOnlyAccess")
+	gitWorktreeMock.AssertCalled(t, "Remove", "users/userName/inlinePolicies/inlinePolicyName")
+	gitWorktreeMock.AssertCalled(t, "Remove", "users/userName")
+	gitWorktreeMock.AssertNumberOfCalls(t, "Commit", 6)
+}
+
+func TestAuditorRolePolicies(t *testing.T) {
+	dir, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	response, gitWorktreeMock := auditEvents(t, cloudtrail.CloudTrailEvent{
+		EventName: "CreateRole",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName:                 "roleName",
+			AssumeRolePolicyDocument: `{"Version":"2012-10-17","Statement":[]}`,
+		},
+		EventTime: "2012-11-01T22:08:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "AttachRolePolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName:  "roleName",
+			PolicyArn: "arn:aws:iam::aws:policy/ReadOnlyAccess",
+		},
+		EventTime: "2012-11-01T22:09:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "AttachRolePolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName:  "roleName",
+			PolicyArn: "arn:aws:iam::aws:policy/ReadOnlyAccess",
+		},
+		EventTime: "2012-11-01T22:09:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "PutRolePolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName:       "roleName",
+			PolicyName:     "inlinePolicyName",
+			PolicyDocument: `{"Version":"2012-10-17","Statement":[]}`,
+		},
+		EventTime: "2012-11-01T22:10:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "DetachRolePolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName:  "roleName",
+			PolicyArn: "arn:aws:iam::aws:policy/ReadOnlyAccess",
+		},
+		EventTime: "2012-11-01T22:11:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "DeleteRolePolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName:   "roleName",
+			PolicyName: "inlinePolicyName",
+		},
+		EventTime: "2012-11-01T22:12:41Z",
+	})
+	assert.Equal(t, 3, response.Added)
+	assert.Equal(t, 2, response.Removed)
+	assert.Equal(t, 1, response.Ignored)
+	assert.Equal(t, "arn:aws:iam::aws:policy/ReadOnlyAccess",
+		readFile(t, dir, "roles/roleName/attachedPolicies/ReadOnlyAccess"))
+	assert.Equal(t, "{\n  \"Statement\": [],\n  \"Version\": \"2012-10-17\"\n}\n",
+		readFile(t, dir, "roles/roleName/inlinePolicies/inlinePolicyName"))
+	gitWorktreeMock.AssertCalled(t, "Remove", "roles/roleName/attachedPolicies/ReadOnlyAccess")
+	gitWorktreeMock.AssertCalled(t, "Remove", "roles/roleName/inlinePolicies/inlinePolicyName")
+	gitWorktreeMock.AssertNumberOfCalls(t, "Commit", 5)
+}
+
+func TestAuditorGroups(t *testing.T) {
+	dir, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	response, gitWorktreeMock := auditEvents(t, cloudtrail.CloudTrailEvent{
+		EventName: "CreateGroup",
+		RequestParameters: cloudtrail.RequestParameters{
+			GroupName: "admins",
+		},
+		ResponseElements: cloudtrail.ResponseElements{
+			Group: cloudtrail.Group{
+				Arn:       "arn:aws:iam::123456789012:group/admins",
+				GroupID:   "AGPAEXAMPLE",
+				GroupName: "admins",
+			},
+		},
+		EventTime: "2012-11-01T22:08:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "AddUserToGroup",
+		RequestParameters: cloudtrail.RequestParameters{
+			GroupName: "admins",
+			UserName:  "zoe",
+		},
+		EventTime: "2012-11-01T22:09:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "AddUserToGroup",
+		RequestParameters: cloudtrail.RequestParameters{
+			GroupName: "admins",
+			UserName:  "alex",
+		},
+		EventTime: "2012-11-01T22:10:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "AddUserToGroup",
+		RequestParameters: cloudtrail.RequestParameters{
+			GroupName: "admins",
+			UserName:  "alex",
+		},
+		EventTime: "2012-11-01T22:11:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "RemoveUserFromGroup",
+		RequestParameters: cloudtrail.RequestParameters{
+			GroupName: "admins",
+			UserName:  "zoe",
+		},
+		EventTime: "2012-11-01T22:12:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "AttachGroupPolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			GroupName: "admins",
+			PolicyArn: "arn:aws:iam::aws:policy/AdministratorAccess",
+		},
+		EventTime: "2012-11-01T22:13:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "PutGroupPolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			GroupName:      "admins",
+			PolicyName:     "inlinePolicyName",
+			PolicyDocument: `{"Version":"2012-10-17","Statement":[]}`,
+		},
+		EventTime: "2012-11-01T22:14:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "DetachGroupPolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			GroupName: "admins",
+			PolicyArn: "arn:aws:iam::aws:policy/AdministratorAccess",
+		},
+		EventTime: "2012-11-01T22:15:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "DeleteGroupPolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			GroupName:  "admins",
+			PolicyName: "inlinePolicyName",
+		},
+		EventTime: "2012-11-01T22:16:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "DeleteGroup",
+		RequestParameters: cloudtrail.RequestParameters{
+			GroupName: "admins",
+		},
+		EventTime: "2012-11-01T22:17:41Z",
+	})
+	assert.Equal(t, 5, response.Added)
+	assert.Equal(t, 4, response.Removed)
+	assert.Equal(t, 1, response.Ignored)
+	assert.Contains(t, readFile(t, dir, "groups/admins/group.json"), `"groupId": "AGPAEXAMPLE"`)
+	assert.Equal(t, "[\n  \"alex\"\n]\n", readFile(t, dir, "groups/admins/members.json"))
+	assert.Equal(t, "arn:aws:iam::aws:policy/AdministratorAccess",
+		readFile(t, dir, "groups/admins/attachedPolicies/AdministratorAccess"))
+	gitWorktreeMock.AssertCalled(t, "Remove", "groups/admins/attachedPolicies/AdministratorAccess")
+	gitWorktreeMock.AssertCalled(t, "Remove", "groups/admins/inlinePolicies/inlinePolicyName")
+	gitWorktreeMock.AssertCalled(t, "Remove", "groups/admins")
+	gitWorktreeMock.AssertNumberOfCalls(t, "Commit", 9)
+}
+
+func TestAuditorAssumeRolePolicy(t *testing.T) {
+	dir, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	response, gitWorktreeMock := auditEvents(t, cloudtrail.CloudTrailEvent{
+		EventName: "CreateRole",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName: "roleName",
+			AssumeRolePolicyDocument: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow",` +
+				`"Principal":{"Service":"lambda.amazonaws.com"},"Action":"sts:AssumeRole"}]}`,
+		},
+		EventTime: "2012-11-01T22:08:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "UpdateAssumeRolePolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName: "roleName",
+			PolicyDocument: `{"Statement":[{"Action":"sts:AssumeRole","Effect":"Allow",` +
+				`"Principal":{"Service":"lambda.amazonaws.com"}}],"Version":"2012-10-17"}`,
+		},
+		EventTime: "2012-11-01T22:09:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "UpdateAssumeRolePolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName: "roleName",
+			PolicyDocument: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow",` +
+				`"Principal":{"AWS":"arn:aws:iam::210987654321:root"},"Action":"sts:AssumeRole"}]}`,
+		},
+		EventTime: "2012-11-01T22:10:41Z",
+	})
+	assert.Equal(t, 2, response.Added)
+	assert.Equal(t, 1, response.Ignored)
+	assert.Equal(t, `{
+  "Statement": [
+    {
+      "Action": "sts:AssumeRole",
+      "Effect": "Allow",
+      "Principal": {
+        "AWS": "arn:aws:iam::210987654321:root"
+      }
+    }
+  ],
+  "Version": "2012-10-17"
+}
+`, readFile(t, dir, "roles/roleName/assumeRolePolicyDocument.json"))
+	gitWorktreeMock.AssertNumberOfCalls(t, "Add", 3)
+	gitWorktreeMock.AssertNumberOfCalls(t, "Commit", 2)
+}
+
+func TestAuditorPermissionsBoundary(t *testing.T) {
+	dir, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	response, gitWorktreeMock := auditEvents(t, cloudtrail.CloudTrailEvent{
+		EventName: "CreateRole",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName:                 "roleName",
+			AssumeRolePolicyDocument: `{"Version":"2012-10-17","Statement":[]}`,
+			PermissionsBoundary:      "arn:aws:iam::123456789012:policy/boundary",
+		},
+		EventTime: "2012-11-01T22:08:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "PutUserPermissionsBoundary",
+		RequestParameters: cloudtrail.RequestParameters{
+			UserName:            "userName",
+			PermissionsBoundary: "arn:aws:iam::123456789012:policy/boundary",
+		},
+		EventTime: "2012-11-01T22:09:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "PutRolePermissionsBoundary",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName:            "roleName",
+			PermissionsBoundary: "arn:aws:iam::123456789012:policy/otherBoundary",
+		},
+		EventTime: "2012-11-01T22:10:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "DeleteUserPermissionsBoundary",
+		RequestParameters: cloudtrail.RequestParameters{
+			UserName: "userName",
+		},
+		EventTime: "2012-11-01T22:11:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "DeleteRolePermissionsBoundary",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName: "otherRoleName",
+		},
+		EventTime: "2012-11-01T22:12:41Z",
+	})
+	assert.Equal(t, 3, response.Added)
+	assert.Equal(t, 1, response.Removed)
+	assert.Equal(t, 1, response.Ignored)
+	assert.Equal(t, "arn:aws:iam::123456789012:policy/otherBoundary",
+		readFile(t, dir, "roles/roleName/permissionsBoundary"))
+	gitWorktreeMock.AssertCalled(t, "Add", "roles/roleName/permissionsBoundary")
+	gitWorktreeMock.AssertCalled(t, "Remove", "users/userName/permissionsBoundary")
+	gitWorktreeMock.AssertNumberOfCalls(t, "Commit", 4)
+}
+
+func TestAuditorTags(t *testing.T) {
+	dir, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	response, gitWorktreeMock := auditEvents(t, cloudtrail.CloudTrailEvent{
+		EventName: "CreateUser",
+		RequestParameters: cloudtrail.RequestParameters{
+			UserName: "userName",
+			Tags:     []cloudtrail.Tag{{Key: "team", Value: "platform"}},
+		},
+		EventTime: "2012-11-01T22:08:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "TagUser",
+		RequestParameters: cloudtrail.RequestParameters{
+			UserName: "userName",
+			Tags:     []cloudtrail.Tag{{Key: "costCenter", Value: "42"}, {Key: "team", Value: "security"}},
+		},
+		EventTime: "2012-11-01T22:09:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "TagRole",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName: "roleName",
+			Tags:     []cloudtrail.Tag{{Key: "team", Value: "platform"}},
+		},
+		EventTime: "2012-11-01T22:10:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "UntagRole",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName: "roleName",
+			TagKeys:  []string{"team"},
+		},
+		EventTime: "2012-11-01T22:11:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "TagPolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			PolicyArn: "arn:aws:iam::123456789012:policy/policyName",
+			Tags:      []cloudtrail.Tag{{Key: "team", Value: "platform"}},
+		},
+		EventTime: "2012-11-01T22:12:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "UntagUser",
+		RequestParameters: cloudtrail.RequestParameters{
+			UserName: "userName",
+			TagKeys:  []string{"unknown"},
+		},
+		EventTime: "2012-11-01T22:13:41Z",
+	})
+	assert.Equal(t, 4, response.Added)
+	assert.Equal(t, 1, response.Removed)
+	assert.Equal(t, 1, response.Ignored)
+	assert.Equal(t, "{\n  \"costCenter\": \"42\",\n  \"team\": \"security\"\n}\n",
+		readFile(t, dir, "users/userName/tags.json"))
+	assert.Equal(t, "{\n  \"team\": \"platform\"\n}\n", readFile(t, dir, "policies/policyName/tags.json"))
+	gitWorktreeMock.AssertCalled(t, "Remove", "roles/roleName/tags.json")
+	gitWorktreeMock.AssertNumberOfCalls(t, "Commit", 5)
+}
+
+func TestAuditorPolicyVersions(t *testing.T) {
+	policyArn := "arn:aws:iam::123456789012:policy/policyName"
+	dir, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	response, gitWorktreeMock := auditEvents(t, cloudtrail.CloudTrailEvent{
+		EventName: "CreatePolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			PolicyName:     "policyName",
+			PolicyDocument: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:GetObject"}]}`,
+		},
+		ResponseElements: cloudtrail.ResponseElements{
+			Policy: cloudtrail.Policy{
+				Arn:              policyArn,
+				DefaultVersionId: "v1",
+			},
+		},
+		EventTime: "2012-11-01T22:08:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "CreatePolicyVersion",
+		RequestParameters: cloudtrail.RequestParameters{
+			PolicyArn:      policyArn,
+			PolicyDocument: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:*"}]}`,
+		},
+		ResponseElements: cloudtrail.ResponseElements{
+			PolicyVersion: cloudtrail.PolicyVersion{
+				VersionId: "v2",
+			},
+		},
+		EventTime: "2012-11-01T22:09:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "CreatePolicyVersion",
+		RequestParameters: cloudtrail.RequestParameters{
+			PolicyArn:      policyArn,
+			PolicyDocument: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"*"}]}`,
+			SetAsDefault:   true,
+		},
+		ResponseElements: cloudtrail.ResponseElements{
+			PolicyVersion: cloudtrail.PolicyVersion{
+				VersionId:        "v3",
+				IsDefaultVersion: true,
+			},
+		},
+		EventTime: "2012-11-01T22:10:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "SetDefaultPolicyVersion",
+		RequestParameters: cloudtrail.RequestParameters{
+			PolicyArn: policyArn,
+			VersionId: "v2",
+		},
+		EventTime: "2012-11-01T22:11:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "DeletePolicyVersion",
+		RequestParameters: cloudtrail.RequestParameters{
+			PolicyArn: policyArn,
+			VersionId: "v3",
+		},
+		EventTime: "2012-11-01T22:12:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "SetDefaultPolicyVersion",
+		RequestParameters: cloudtrail.RequestParameters{
+			PolicyArn: "arn:aws:iam::123456789012:policy/unauditedPolicyName",
+			VersionId: "v4",
+		},
+		EventTime: "2012-11-01T22:13:41Z",
+	})
+	assert.Equal(t, 5, response.Added)
+	assert.Equal(t, 1, response.Removed)
+	assert.Equal(t, 0, response.Ignored)
+	assert.Equal(t, "v2", readFile(t, dir, "policies/policyName/default"))
+	assert.Contains(t, readFile(t, dir, "policies/policyName/versions/v1.json"), `"Action": "s3:GetObject"`)
+	assert.Contains(t, readFile(t, dir, "policies/policyName/versions/v2.json"), `"Action": "s3:*"`)
+	gitWorktreeMock.AssertCalled(t, "Remove", "policies/policyName/versions/v3.json")
+	assert.Equal(t, "v4", readFile(t, dir, "policies/unauditedPolicyName/default"))
+	assert.Equal(t, "{\n  \"Statement\": [],\n  \"Version\": \"2012-10-17\"\n}\n",
+		readFile(t, dir, "policies/unauditedPolicyName/versions/v4.json"))
+}
+
+func TestAuditorLegacyPolicyFile(t *testing.T) {
+	dir, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	err := os.Mkdir(dir+"/policies", 0744)
+	assert.Nil(t, err)
+	for _, policyName := range []string{"policyName", "otherPolicyName"} {
+		err = ioutil.WriteFile(dir+"/policies/"+policyName, []byte(`{"Statement":[]}`), 0644)
+		assert.Nil(t, err)
+	}
+	response, gitWorktreeMock := auditEvents(t, cloudtrail.CloudTrailEvent{
+		EventName: "CreatePolicyVersion",
+		RequestParameters: cloudtrail.RequestParameters{
+			PolicyArn:      "arn:aws:iam::123456789012:policy/policyName",
+			PolicyDocument: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:*"}]}`,
+			SetAsDefault:   true,
+		},
+		ResponseElements: cloudtrail.ResponseElements{
+			PolicyVersion: cloudtrail.PolicyVersion{
+				VersionId: "v2",
+			},
+		},
+		EventTime: "2012-11-01T22:08:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "CreatePolicyVersion",
+		RequestParameters: cloudtrail.RequestParameters{
+			PolicyArn:      "arn:aws:iam::123456789012:policy/otherPolicyName",
+			PolicyDocument: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:*"}]}`,
+		},
+		ResponseElements: cloudtrail.ResponseElements{
+			PolicyVersion: cloudtrail.PolicyVersion{
+				VersionId: "v2",
+			},
+		},
+		EventTime: "2012-11-01T22:09:41Z",
+	})
+	assert.Equal(t, 2, response.Added)
+	assert.Equal(t, 0, response.Ignored)
+	gitWorktreeMock.AssertCalled(t, "Remove", "policies/policyName")
+	assert.Equal(t, `{"Statement":[]}`, readFile(t, dir, "policies/policyName/versions/legacy.json"))
+	assert.Contains(t, readFile(t, dir, "policies/policyName/versions/v2.json"), `"Action": "s3:*"`)
+	assert.Equal(t, "v2", readFile(t, dir, "policies/policyName/default"))
+	// The moved document stays the default version until another version is set as the default.
+	gitWorktreeMock.AssertCalled(t, "Remove", "policies/otherPolicyName")
+	assert.Equal(t, `{"Statement":[]}`, readFile(t, dir, "policies/otherPolicyName/versions/legacy.json"))
+	assert.Contains(t, readFile(t, dir, "policies/otherPolicyName/versions/v2.json"), `"Action": "s3:*"`)
+	assert.Equal(t, "legacy", readFile(t, dir, "policies/otherPolicyName/default"))
+}
+
+func TestAuditorInstanceProfiles(t *testing.T) {
+	dir, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	response, gitWorktreeMock := auditEvents(t, cloudtrail.CloudTrailEvent{
+		EventName: "CreateInstanceProfile",
+		RequestParameters: cloudtrail.RequestParameters{
+			InstanceProfileName: "instanceProfileName",
+		},
+		ResponseElements: cloudtrail.ResponseElements{
+			InstanceProfile: cloudtrail.InstanceProfile{
+				Arn:                 "arn:aws:iam::123456789012:instance-profile/instanceProfileName",
+				InstanceProfileID:   "AIPAEXAMPLE",
+				InstanceProfileName: "instanceProfileName",
+			},
+		},
+		EventTime: "2012-11-01T22:08:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "AddRoleToInstanceProfile",
+		RequestParameters: cloudtrail.RequestParameters{
+			InstanceProfileName: "instanceProfileName",
+			RoleName:            "roleName",
+		},
+		EventTime: "2012-11-01T22:09:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "AddRoleToInstanceProfile",
+		RequestParameters: cloudtrail.RequestParameters{
+			InstanceProfileName: "otherInstanceProfileName",
+			RoleName:            "roleName",
+		},
+		EventTime: "2012-11-01T22:10:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "RemoveRoleFromInstanceProfile",
+		RequestParameters: cloudtrail.RequestParameters{
+			InstanceProfileName: "instanceProfileName",
+			RoleName:            "roleName",
+		},
+		EventTime: "2012-11-01T22:11:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "RemoveRoleFromInstanceProfile",
+		RequestParameters: cloudtrail.RequestParameters{
+			InstanceProfileName: "instanceProfileName",
+			RoleName:            "roleName",
+		},
+		EventTime: "2012-11-01T22:12:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "DeleteInstanceProfile",
+		RequestParameters: cloudtrail.RequestParameters{
+			InstanceProfileName: "instanceProfileName",
+		},
+		EventTime: "2012-11-01T22:13:41Z",
+	})
+	assert.Equal(t, 3, response.Added)
+	assert.Equal(t, 2, response.Removed)
+	assert.Equal(t, 1, response.Ignored)
+	assert.Contains(t, readFile(t, dir, "instanceProfiles/instanceProfileName/instanceProfile.json"),
+		`"instanceProfileId": "AIPAEXAMPLE"`)
+	assert.Equal(t, "[]\n", readFile(t, dir, "instanceProfiles/instanceProfileName/roles.json"))
+	assert.Equal(t, "[\n  \"roleName\"\n]\n", readFile(t, dir, "instanceProfiles/otherInstanceProfileName/roles.json"))
+	assert.Equal(t, "[\n  \"otherInstanceProfileName\"\n]\n", readFile(t, dir, "roles/roleName/instanceProfiles.json"))
+	gitWorktreeMock.AssertCalled(t, "Remove", "instanceProfiles/instanceProfileName")
+	gitWorktreeMock.AssertNumberOfCalls(t, "Commit", 5)
+}
+
+func TestAuditorCredentials(t *testing.T) {
+	dir, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	response, gitWorktreeMock := auditBodies(t, `{
+		"eventName": "CreateAccessKey",
+		"eventTime": "2012-11-01T22:08:41Z",
+		"userIdentity": {"type": "IAMUser", "userName": "userName"},
+		"responseElements": {"accessKey": {
+			"accessKeyId": "AKIAEXAMPLE",
+			"createDate": "Nov 1, 2012 10:08:41 PM",
+			"secretAccessKey": "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY",
+			"status": "Active",
+			"userName": "userName"
+		}}
+	}`, `{
+		"eventName": "UpdateAccessKey",
+		"eventTime": "2012-11-01T22:09:41Z",
+		"requestParameters": {"accessKeyId": "AKIAEXAMPLE", "status": "Inactive", "userName": "userName"}
+	}`, `{
+		"eventName": "CreateLoginProfile",
+		"eventTime": "2012-11-01T22:10:41Z",
+		"requestParameters": {"userName": "userName", "password": "correct horse battery staple",
+			"passwordResetRequired": true}
+	}`, `{
+		"eventName": "EnableMFADevice",
+		"eventTime": "2012-11-01T22:11:41Z",
+		"requestParameters": {"userName": "userName", "serialNumber": "arn:aws:iam::123456789012:mfa/userName",
+			"authenticationCode1": "123456", "authenticationCode2": "654321"}
+	}`, `{
+		"eventName": "DeactivateMFADevice",
+		"eventTime": "2012-11-01T22:12:41Z",
+		"requestParameters": {"userName": "userName", "serialNumber": "arn:aws:iam::123456789012:mfa/userName"}
+	}`, `{
+		"eventName": "DeleteAccessKey",
+		"eventTime": "2012-11-01T22:13:41Z",
+		"requestParameters": {"accessKeyId": "AKIAEXAMPLE", "userName": "userName"}
+	}`, `{
+		"eventName": "DeleteLoginProfile",
+		"eventTime": "2012-11-01T22:14:41Z",
+		"requestParameters": {"userName": "otherUserName"}
+	}`)
+	assert.Equal(t, 4, response.Added)
+	assert.Equal(t, 2, response.Removed)
+	assert.Equal(t, 1, response.Ignored)
+	accessKey := readFile(t, dir, "users/userName/accessKeys/AKIAEXAMPLE.json")
+	assert.Contains(t, accessKey, `"status": "Inactive"`)
+	assert.Contains(t, accessKey, `"createDate": "Nov 1, 2012 10:08:41 PM"`)
+	assert.NotContains(t, accessKey, "wJalrXUtnFEMI")
+	loginProfile := readFile(t, dir, "users/userName/loginProfile.json")
+	assert.Contains(t, loginProfile, `"passwordResetRequired": true`)
+	assert.NotContains(t, loginProfile, "correct horse")
+	mfaDevice := readFile(t, dir, "users/userName/mfaDevices/userName.json")
+	assert.Contains(t, mfaDevice, `"enableDate": "2012-11-01T22:11:41Z"`)
+	assert.NotContains(t, mfaDevice, "654321")
+	gitWorktreeMock.AssertCalled(t, "Remove", "users/userName/mfaDevices/userName.json")
+	gitWorktreeMock.AssertCalled(t, "Remove", "users/userName/accessKeys/AKIAEXAMPLE.json")
+}
+
+func TestAuditorIdentityProviders(t *testing.T) {
+	oidcProviderArn := "arn:aws:iam::123456789012:oidc-provider/token.actions.githubusercontent.com"
+	dir, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	response, gitWorktreeMock := auditEvents(t, cloudtrail.CloudTrailEvent{
+		EventName: "CreateOpenIDConnectProvider",
+		RequestParameters: cloudtrail.RequestParameters{
+			URL:            "https://token.actions.githubusercontent.com",
+			ClientIDList:   []string{"sts.amazonaws.com"},
+			ThumbprintList: []string{"6938fd4d98bab03faadb97b34396831e3780aea1", "1c58a3a8518e8759bf075b76b750d4f2df264fcd"},
+		},
+		ResponseElements: cloudtrail.ResponseElements{
+			OpenIDConnectProviderArn: oidcProviderArn,
+		},
+		EventTime: "2012-11-01T22:08:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "AddClientIDToOpenIDConnectProvider",
+		RequestParameters: cloudtrail.RequestParameters{
+			OpenIDConnectProviderArn: oidcProviderArn,
+			ClientID:                 "*",
+		},
+		EventTime: "2012-11-01T22:09:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "UpdateOpenIDConnectProviderThumbprint",
+		RequestParameters: cloudtrail.RequestParameters{
+			OpenIDConnectProviderArn: oidcProviderArn,
+			ThumbprintList:           []string{"6938fd4d98bab03faadb97b34396831e3780aea1"},
+		},
+		EventTime: "2012-11-01T22:10:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "CreateSAMLProvider",
+		RequestParameters: cloudtrail.RequestParameters{
+			Name:                 "okta",
+			SAMLMetadataDocument: "<EntityDescriptor/>",
+		},
+		EventTime: "2012-11-01T22:11:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "UpdateSAMLProvider",
+		RequestParameters: cloudtrail.RequestParameters{
+			SAMLProviderArn:      "arn:aws:iam::123456789012:saml-provider/okta",
+			SAMLMetadataDocument: "<EntityDescriptor entityID=\"okta\"/>",
+		},
+		EventTime: "2012-11-01T22:12:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "DeleteSAMLProvider",
+		RequestParameters: cloudtrail.RequestParameters{
+			SAMLProviderArn: "arn:aws:iam::123456789012:saml-provider/okta",
+		},
+		EventTime: "2012-11-01T22:13:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "DeleteOpenIDConnectProvider",
+		RequestParameters: cloudtrail.RequestParameters{
+			OpenIDConnectProviderArn: "arn:aws:iam::123456789012:oidc-provider/accounts.google.com",
+		},
+		EventTime: "2012-11-01T22:14:41Z",
+	})
+	assert.Equal(t, 5, response.Added)
+	assert.Equal(t, 1, response.Removed)
+	assert.Equal(t, 1, response.Ignored)
+	oidcProviderDir := "identityProviders/oidc/token.actions.githubusercontent.com"
+	assert.Equal(t, "[\n  \"*\",\n  \"sts.amazonaws.com\"\n]\n", readFile(t, dir, oidcProviderDir+"/clientIds.json"))
+	assert.Equal(t, "[\n  \"6938fd4d98bab03faadb97b34396831e3780aea1\"\n]\n",
+		readFile(t, dir, oidcProviderDir+"/thumbprints.json"))
+	assert.Equal(t, "<EntityDescriptor entityID=\"okta\"/>", readFile(t, dir, "identityProviders/saml/okta/metadata.xml"))
+	gitWorktreeMock.AssertCalled(t, "Remove", "identityProviders/saml/okta")
+}
+
+func TestAuditorOrganizationsPolicies(t *testing.T) {
+	dir, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	response, gitWorktreeMock := auditEvents(t, cloudtrail.CloudTrailEvent{
+		EventSource: "organizations.amazonaws.com",
+		EventName:   "CreatePolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			Content: `{"Version":"2012-10-17","Statement":[{"Effect":"Deny","Action":"iam:CreateUser",` +
+				`"Resource":"*"}]}`,
+			Name: "denyCreateUser",
+			Type: "SERVICE_CONTROL_POLICY",
+		},
+		ResponseElements: cloudtrail.ResponseElements{
+			Policy: cloudtrail.Policy{
+				PolicySummary: cloudtrail.PolicySummary{
+					ID:   "p-examplepolicyid111",
+					Name: "denyCreateUser",
+					Type: "SERVICE_CONTROL_POLICY",
+				},
+			},
+		},
+		EventTime: "2012-11-01T22:08:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource: "organizations.amazonaws.com",
+		EventName:   "AttachPolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			PolicyId: "p-examplepolicyid111",
+			TargetId: "ou-examplerootid111-exampleouid111",
+		},
+		EventTime: "2012-11-01T22:09:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource: "organizations.amazonaws.com",
+		EventName:   "AttachPolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			PolicyId: "p-examplepolicyid111",
+			TargetId: "111111111111",
+		},
+		EventTime: "2012-11-01T22:10:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource: "organizations.amazonaws.com",
+		EventName:   "UpdatePolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			PolicyId:    "p-examplepolicyid111",
+			Description: "Only the platform team creates users",
+			Content:     `{"Version":"2012-10-17","Statement":[]}`,
+		},
+		EventTime: "2012-11-01T22:11:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource: "organizations.amazonaws.com",
+		EventName:   "DetachPolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			PolicyId: "p-examplepolicyid111",
+			TargetId: "111111111111",
+		},
+		EventTime: "2012-11-01T22:12:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource: "organizations.amazonaws.com",
+		EventName:   "DeletePolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			PolicyId: "p-examplepolicyid222",
+		},
+		EventTime: "2012-11-01T22:13:41Z",
+	})
+	assert.Equal(t, 4, response.Added)
+	assert.Equal(t, 1, response.Removed)
+	assert.Equal(t, 1, response.Ignored)
+	policyDir := "organizations/policies/p-examplepolicyid111"
+	policy := readFile(t, dir, policyDir+"/policy.json")
+	assert.Contains(t, policy, `"name": "denyCreateUser"`)
+	assert.Contains(t, policy, `"description": "Only the platform team creates users"`)
+	assert.Equal(t, "{\n  \"Statement\": [],\n  \"Version\": \"2012-10-17\"\n}\n",
+		readFile(t, dir, policyDir+"/content.json"))
+	assert.Equal(t, "[\n  \"ou-examplerootid111-exampleouid111\"\n]\n", readFile(t, dir, policyDir+"/targets.json"))
+	assert.Equal(t, "[\n  \"p-examplepolicyid111\"\n]\n",
+		readFile(t, dir, "organizations/targets/ou-examplerootid111-exampleouid111/policies.json"))
+	assert.Equal(t, "[]\n", readFile(t, dir, "organizations/targets/111111111111/policies.json"))
+	_, err := os.Stat(dir + "/policies")
+	assert.True(t, os.IsNotExist(err))
+	gitWorktreeMock.AssertNumberOfCalls(t, "Commit", 5)
+}
+
+func TestAuditorResourcePolicies(t *testing.T) {
+	publicPolicy := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"*"}]}`
+	dir, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	response, gitWorktreeMock := auditEvents(t, cloudtrail.CloudTrailEvent{
+		EventSource: "s3.amazonaws.com",
+		EventName:   "PutBucketPolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			BucketName:   "bucketName",
+			BucketPolicy: json.RawMessage(publicPolicy),
+		},
+		EventTime: "2012-11-01T22:08:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource: "s3.amazonaws.com",
+		EventName:   "DeleteBucketPolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			BucketName: "bucketName",
+		},
+		EventTime: "2012-11-01T22:09:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource: "kms.amazonaws.com",
+		EventName:   "PutKeyPolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			KeyID:      "arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab",
+			PolicyName: "default",
+			Policy:     publicPolicy,
+		},
+		EventTime: "2012-11-01T22:10:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource:        "sqs.amazonaws.com",
+		AWSRegion:          "us-east-1",
+		RecipientAccountID: "123456789012",
+		EventName:          "SetQueueAttributes",
+		RequestParameters: cloudtrail.RequestParameters{
+			QueueURL:   "https://sqs.us-east-1.amazonaws.com/123456789012/queueName",
+			Attributes: map[string]string{"Policy": publicPolicy},
+		},
+		EventTime: "2012-11-01T22:11:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource:        "sqs.amazonaws.com",
+		AWSRegion:          "us-east-1",
+		RecipientAccountID: "123456789012",
+		EventName:          "SetQueueAttributes",
+		RequestParameters: cloudtrail.RequestParameters{
+			QueueURL:   "https://sqs.us-east-1.amazonaws.com/123456789012/queueName",
+			Attributes: map[string]string{"VisibilityTimeout": "60"},
+		},
+		EventTime: "2012-11-01T22:12:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource:        "sqs.amazonaws.com",
+		AWSRegion:          "eu-west-1",
+		RecipientAccountID: "123456789012",
+		EventName:          "SetQueueAttributes",
+		RequestParameters: cloudtrail.RequestParameters{
+			QueueURL:   "https://sqs.eu-west-1.amazonaws.com/123456789012/queueName",
+			Attributes: map[string]string{"Policy": `{"Version":"2012-10-17","Statement":[]}`},
+		},
+		EventTime: "2012-11-01T22:12:51Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource:        "sns.amazonaws.com",
+		AWSRegion:          "us-east-1",
+		RecipientAccountID: "123456789012",
+		EventName:          "SetTopicAttributes",
+		RequestParameters: cloudtrail.RequestParameters{
+			TopicArn:       "arn:aws:sns:us-east-1:123456789012:topicName",
+			AttributeName:  "Policy",
+			AttributeValue: publicPolicy,
+		},
+		EventTime: "2012-11-01T22:13:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource:        "lambda.amazonaws.com",
+		AWSRegion:          "us-east-1",
+		RecipientAccountID: "123456789012",
+		EventName:          "AddPermission20150331v2",
+		RequestParameters: cloudtrail.RequestParameters{
+			FunctionName: "arn:aws:lambda:us-east-1:123456789012:function:functionName",
+			StatementID:  "s3",
+			Action:       "lambda:InvokeFunction",
+			Principal:    "s3.amazonaws.com",
+		},
+		ResponseElements: cloudtrail.ResponseElements{
+			Statement: `{"Sid":"s3","Effect":"Allow","Principal":{"Service":"s3.amazonaws.com"},` +
+				`"Action":"lambda:InvokeFunction"}`,
+		},
+		EventTime: "2012-11-01T22:14:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource:        "lambda.amazonaws.com",
+		AWSRegion:          "us-east-1",
+		RecipientAccountID: "123456789012",
+		EventName:          "AddPermission20150331v2",
+		RequestParameters: cloudtrail.RequestParameters{
+			FunctionName: "functionName",
+			StatementID:  "everyone",
+			Action:       "lambda:InvokeFunction",
+			Principal:    "*",
+		},
+		EventTime: "2012-11-01T22:15:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource:        "lambda.amazonaws.com",
+		AWSRegion:          "us-east-1",
+		RecipientAccountID: "123456789012",
+		EventName:          "RemovePermission20150331v2",
+		RequestParameters: cloudtrail.RequestParameters{
+			FunctionName: "functionName",
+			StatementID:  "s3",
+		},
+		EventTime: "2012-11-01T22:16:41Z",
+	})
+	assert.Equal(t, 7, response.Added)
+	assert.Equal(t, 2, response.Removed)
+	assert.Equal(t, 1, response.Ignored)
+	gitWorktreeMock.AssertCalled(t, "Remove", "resourcePolicies/s3/bucketName.json")
+	assert.Contains(t, readFile(t, dir, "resourcePolicies/kms/1234abcd-12ab-34cd-56ef-1234567890ab.json"),
+		`"Principal": "*"`)
+	assert.Contains(t, readFile(t, dir, "resourcePolicies/sqs/123456789012/us-east-1/queueName.json"),
+		`"Principal": "*"`)
+	assert.Equal(t, "{\n  \"Statement\": [],\n  \"Version\": \"2012-10-17\"\n}\n",
+		readFile(t, dir, "resourcePolicies/sqs/123456789012/eu-west-1/queueName.json"))
+	assert.Contains(t, readFile(t, dir, "resourcePolicies/sns/123456789012/us-east-1/topicName.json"),
+		`"Principal": "*"`)
+	assert.Equal(t, `{
+  "everyone": {
+    "action": "lambda:InvokeFunction",
+    "principal": "*"
+  }
+}
+`, readFile(t, dir, "resourcePolicies/lambda/123456789012/us-east-1/functionName.json"))
+}
+
+func TestAuditorPermissionSets(t *testing.T) {
+	permissionSetArn := "arn:aws:sso:::permissionSet/ssoins-1111111111111111/ps-1111111111111111"
+	dir, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	response, gitWorktreeMock := auditEvents(t, cloudtrail.CloudTrailEvent{
+		EventSource: "sso.amazonaws.com",
+		EventName:   "CreatePermissionSet",
+		RequestParameters: cloudtrail.RequestParameters{
+			Name:            "AdministratorAccess",
+			SessionDuration: "PT1H",
+		},
+		ResponseElements: cloudtrail.ResponseElements{
+			PermissionSet: cloudtrail.PermissionSet{
+				Name:             "AdministratorAccess",
+				PermissionSetArn: permissionSetArn,
+				SessionDuration:  "PT1H",
+			},
+		},
+		EventTime: "2012-11-01T22:08:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource: "sso.amazonaws.com",
+		EventName:   "AttachManagedPolicyToPermissionSet",
+		RequestParameters: cloudtrail.RequestParameters{
+			PermissionSetArn: permissionSetArn,
+			ManagedPolicyArn: "arn:aws:iam::aws:policy/AdministratorAccess",
+		},
+		EventTime: "2012-11-01T22:09:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource: "sso.amazonaws.com",
+		EventName:   "PutInlinePolicyToPermissionSet",
+		RequestParameters: cloudtrail.RequestParameters{
+			PermissionSetArn: permissionSetArn,
+			InlinePolicy:     `{"Version":"2012-10-17","Statement":[]}`,
+		},
+		EventTime: "2012-11-01T22:10:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource: "sso.amazonaws.com",
+		EventName:   "CreateAccountAssignment",
+		RequestParameters: cloudtrail.RequestParameters{
+			PermissionSetArn: permissionSetArn,
+			TargetId:         "111111111111",
+			TargetType:       "AWS_ACCOUNT",
+			PrincipalType:    "USER",
+			PrincipalId:      "90676d2f47-11111111-1111-1111-1111-111111111111",
+		},
+		EventTime: "2012-11-01T22:11:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource: "sso.amazonaws.com",
+		EventName:   "ProvisionPermissionSet",
+		RequestParameters: cloudtrail.RequestParameters{
+			PermissionSetArn: permissionSetArn,
+			TargetType:       "ALL_PROVISIONED_ACCOUNTS",
+		},
+		EventTime: "2012-11-01T22:12:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource: "sso.amazonaws.com",
+		EventName:   "DetachManagedPolicyFromPermissionSet",
+		RequestParameters: cloudtrail.RequestParameters{
+			PermissionSetArn: permissionSetArn,
+			ManagedPolicyArn: "arn:aws:iam::aws:policy/AdministratorAccess",
+		},
+		EventTime: "2012-11-01T22:13:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventSource: "sso.amazonaws.com",
+		EventName:   "DeleteAccountAssignment",
+		RequestParameters: cloudtrail.RequestParameters{
+			PermissionSetArn: "arn:aws:sso:::permissionSet/ssoins-1111111111111111/ps-2222222222222222",
+			TargetId:         "111111111111",
+			TargetType:       "AWS_ACCOUNT",
+			PrincipalType:    "GROUP",
+			PrincipalId:      "90676d2f47-22222222-2222-2222-2222-222222222222",
+		},
+		EventTime: "2012-11-01T22:14:41Z",
+	})
+	assert.Equal(t, 5, response.Added)
+	assert.Equal(t, 1, response.Removed)
+	assert.Equal(t, 1, response.Ignored)
+	permissionSetDir := "permissionSets/AdministratorAccess"
+	assert.Contains(t, readFile(t, dir, permissionSetDir+"/permissionSet.json"), `"sessionDuration": "PT1H"`)
+	assert.Equal(t, "[]\n", readFile(t, dir, permissionSetDir+"/managedPolicies.json"))
+	assert.Equal(t, "[\n  \"111111111111/USER/90676d2f47-11111111-1111-1111-1111-111111111111\"\n]\n",
+		readFile(t, dir, permissionSetDir+"/assignments.json"))
+	assert.Equal(t, "{\n  \"Statement\": [],\n  \"Version\": \"2012-10-17\"\n}\n",
+		readFile(t, dir, permissionSetDir+"/inlinePolicy.json"))
+	assert.Contains(t, readFile(t, dir, permissionSetDir+"/provisioning.json"), `"provisionedAt": "2012-11-01T22:12:41Z"`)
+	gitWorktreeMock.AssertNumberOfCalls(t, "Commit", 6)
+}
+
+func TestAuditorRoleMetadata(t *testing.T) {
+	dir, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	response, _ := auditEvents(t, cloudtrail.CloudTrailEvent{
+		EventName: "CreateRole",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName:                 "roleName",
+			Path:                     "/service/",
+			Description:              "Deploys the application",
+			AssumeRolePolicyDocument: `{"Version":"2012-10-17","Statement":[]}`,
+		},
+		ResponseElements: cloudtrail.ResponseElements{
+			Role: cloudtrail.Role{
+				Arn:                "arn:aws:iam::123456789012:role/service/roleName",
+				CreateDate:         "Nov 1, 2012 10:08:41 PM",
+				MaxSessionDuration: 3600,
+				Path:               "/service/",
+				RoleID:             "AROAEXAMPLE",
+				RoleName:           "roleName",
+			},
+		},
+		EventTime: "2012-11-01T22:08:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "UpdateRole",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName:           "roleName",
+			MaxSessionDuration: 43200,
+		},
+		EventTime: "2012-11-01T22:09:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "UpdateRoleDescription",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName:    "roleName",
+			Description: "Deploys and operates the application",
+		},
+		EventTime: "2012-11-01T22:10:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "UpdateRole",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName:           "roleName",
+			MaxSessionDuration: 43200,
+		},
+		EventTime: "2012-11-01T22:11:41Z",
+	})
+	assert.Equal(t, 3, response.Added)
+	assert.Equal(t, 1, response.Ignored)
+	assert.Equal(t, `{
+  "arn": "arn:aws:iam::123456789012:role/service/roleName",
+  "createDate": "Nov 1, 2012 10:08:41 PM",
+  "description": "Deploys and operates the application",
+  "maxSessionDuration": 43200,
+  "path": "/service/",
+  "roleId": "AROAEXAMPLE",
+  "roleName": "roleName"
+}
+`, readFile(t, dir, "roles/roleName/role.json"))
+}
+
+func TestAuditorRecreatedPrincipals(t *testing.T) {
+	dir, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	response, gitWorktreeMock := auditEvents(t, cloudtrail.CloudTrailEvent{
+		EventName: "CreateRole",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName: "roleName",
+		},
+		ResponseElements: cloudtrail.ResponseElements{
+			Role: cloudtrail.Role{
+				Arn:      "arn:aws:iam::123456789012:role/roleName",
+				RoleID:   "AROAFIRST",
+				RoleName: "roleName",
+			},
+		},
+		EventTime: "2012-11-01T22:08:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "DeleteRole",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName: "roleName",
+		},
+		EventTime: "2012-11-01T22:09:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "CreateRole",
+		RequestParameters: cloudtrail.RequestParameters{
+			RoleName: "roleName",
+		},
+		ResponseElements: cloudtrail.ResponseElements{
+			Role: cloudtrail.Role{
+				Arn:      "arn:aws:iam::123456789012:role/roleName",
+				RoleID:   "AROASECOND",
+				RoleName: "roleName",
+			},
+		},
+		EventTime: "2012-11-01T22:10:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "CreateUser",
+		RequestParameters: cloudtrail.RequestParameters{
+			UserName: "userName",
+		},
+		ResponseElements: cloudtrail.ResponseElements{
+			User: cloudtrail.User{
+				UserID:   "AIDAFIRST",
+				UserName: "userName",
+			},
+		},
+		EventTime: "2012-11-01T22:11:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "CreateUser",
+		RequestParameters: cloudtrail.RequestParameters{
+			UserName: "userName",
+		},
+		ResponseElements: cloudtrail.ResponseElements{
+			User: cloudtrail.User{
+				UserID:   "AIDASECOND",
+				UserName: "userName",
+			},
+		},
+		EventTime: "2012-11-01T22:12:41Z",
+	})
+	assert.Equal(t, 4, response.Added)
+	assert.Equal(t, 1, response.Removed)
+	assert.Equal(t, `[
+  {
+    "id": "AROAFIRST",
+    "arn": "arn:aws:iam::123456789012:role/roleName",
+    "createdAt": "2012-11-01T22:08:41Z",
+    "deletedAt": "2012-11-01T22:09:41Z"
+  },
+  {
+    "id": "AROASECOND",
+    "arn": "arn:aws:iam::123456789012:role/roleName",
+    "createdAt": "2012-11-01T22:10:41Z"
+  }
+]
+`, readFile(t, dir, "history/roles/roleName.json"))
+	assert.Equal(t, `[
+  {
+    "id": "AIDAFIRST",
+    "createdAt": "2012-11-01T22:11:41Z"
+  },
+  {
+    "id": "AIDASECOND",
+    "createdAt": "2012-11-01T22:12:41Z"
+  }
+]
+`, readFile(t, dir, "history/users/userName.json"))
+	assert.Contains(t, readFile(t, dir, "roles/roleName/role.json"), `"roleId": "AROASECOND"`)
+	gitWorktreeMock.AssertCalled(t, "Commit", "CreateRole by \n\nRecreated: roles/roleName was AROAFIRST, now AROASECOND",
+		mock.AnythingOfType("*git.CommitOptions"))
+	gitWorktreeMock.AssertCalled(t, "Commit", "CreateUser by \n\nRecreated: users/userName was AIDAFIRST, now AIDASECOND",
+		mock.AnythingOfType("*git.CommitOptions"))
+}
+
+func TestRecordCreation(t *testing.T) {
+	_, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	gitWorktreeMock := new(MockGitWorktree)
+	gitWorktreeMock.On("Add", mock.AnythingOfType("string")).Return(plumbing.Hash{}, nil)
+	historyFile := "history/roles/roleName.json"
+
+	first := incarnation{ID: "AROAFIRST", CreatedAt: "2012-11-01T22:08:41Z"}
+	assert.Nil(t, recordCreation(gitWorktreeMock, historyFile, "roles/roleName", "", first))
+	// A creation delivered again, whether or not the principal is still there, is not a recreation.
+	assert.Nil(t, recordCreation(gitWorktreeMock, historyFile, "roles/roleName", "AROAFIRST", first))
+	assert.Nil(t, recordCreation(gitWorktreeMock, historyFile, "roles/roleName", "", first))
+	second := incarnation{ID: "AROASECOND", CreatedAt: "2012-11-01T22:10:41Z"}
+	assert.Equal(t, []string{"Recreated: roles/roleName was AROAFIRST, now AROASECOND"},
+		recordCreation(gitWorktreeMock, historyFile, "roles/roleName", "", second))
+	assert.Nil(t, recordCreation(gitWorktreeMock, historyFile, "roles/roleName", "AROASECOND", second))
+	assert.Nil(t, recordCreation(gitWorktreeMock, historyFile, "roles/roleName", "", second))
+}
+
+func TestAuditorAccountSettings(t *testing.T) {
+	dir, restoreTempDir := useTempDir(t)
+	defer restoreTempDir()
+	response, gitWorktreeMock := auditEvents(t, cloudtrail.CloudTrailEvent{
+		EventName: "UpdateAccountPasswordPolicy",
+		RequestParameters: cloudtrail.RequestParameters{
+			RequireSymbols:          true,
+			RequireNumbers:          true,
+			PasswordReusePrevention: 24,
+		},
+		EventTime: "2012-11-01T22:08:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "CreateAccountAlias",
+		RequestParameters: cloudtrail.RequestParameters{
+			AccountAlias: "accountAlias",
+		},
+		EventTime: "2012-11-01T22:09:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "SetSecurityTokenServicePreferences",
+		RequestParameters: cloudtrail.RequestParameters{
+			GlobalEndpointTokenVersion: "v2Token",
+		},
+		EventTime: "2012-11-01T22:10:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "SetSecurityTokenServicePreferences",
+		RequestParameters: cloudtrail.RequestParameters{
+			GlobalEndpointTokenVersion: "v2Token",
+		},
+		EventTime: "2012-11-01T22:11:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "DeleteAccountAlias",
+		RequestParameters: cloudtrail.RequestParameters{
+			AccountAlias: "accountAlias",
+		},
+		EventTime: "2012-11-01T22:12:41Z",
+	}, cloudtrail.CloudTrailEvent{
+		EventName: "DeleteAccountPasswordPolicy",
+		EventTime: "2012-11-01T22:13:41Z",
+	})
+	assert.Equal(t, 3, response.Added)
+	assert.Equal(t, 2, response.Removed)
+	assert.Equal(t, 1, response.Ignored)
+	assert.Equal(t, `{
+  "allowUsersToChangePassword": false,
+  "hardExpiry": false,
+  "maxPasswordAge": 0,
+  "minimumPasswordLength": 6,
+  "passwordReusePrevention": 24,
+  "requireLowercaseCharacters": false,
+  "requireNumbers": true,
+  "requireSymbols": true,
+  "requireUppercaseCharacters": false
+}
+`, readFile(t, dir, "account/passwordPolicy.json"))
+	assert.Equal(t, "{\n  \"accountAlias\": \"accountAlias\"\n}\n", readFile(t, dir, "account/alias.json"))
+	assert.Equal(t, "{\n  \"globalEndpointTokenVersion\": \"v2Token\"\n}\n",
+		readFile(t, dir, "account/stsPreferences.json"))
+	gitWorktreeMock.AssertCalled(t, "Remove", "account/alias.json")
+	gitWorktreeMock.AssertCalled(t, "Remove", "account/passwordPolicy.json")
+	gitWorktreeMock.AssertNumberOfCalls(t, "Commit", 5)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"github.com/dlabey/iam-git-auditor/pkg/cloudtrail"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const defaultEventSources = "iam.amazonaws.com,organizations.amazonaws.com,s3.amazonaws.com,kms.amazonaws.com," +
+	"sqs.amazonaws.com,sns.amazonaws.com,lambda.amazonaws.com,sso.amazonaws.com"
+
+// The event names of the services the Auditor only keeps resource policies of, which otherwise log far more events,
+// data events such as PutObject, SendMessage, Publish and Invoke included, than the policy changes it audits.
+var defaultSourceEventNames = map[string]map[string]bool{
+	"kms.amazonaws.com":    {"PutKeyPolicy": true},
+	"lambda.amazonaws.com": {"AddPermission": true, "RemovePermission": true},
+	"s3.amazonaws.com":     {"DeleteBucketPolicy": true, "PutBucketPolicy": true},
+	"sns.amazonaws.com":    {"SetTopicAttributes": true},
+	"sqs.amazonaws.com":    {"SetQueueAttributes": true},
+}
+
+// Matches the API version some event names end with, such as the one of AddPermission20150331v2.
+var apiVersionPattern = regexp.MustCompile(`\d{8}(v\d+)?$`)
+
+type filter struct {
+	EventSources     map[string]bool
+	EventNames       map[string]bool
+	SourceEventNames map[string]map[string]bool
+	SkipReadOnly     bool
+	SkipErrorCode    bool
+}
+
+// Splits a comma separated list into a set, ignoring blank entries.
+func parseSet(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			set[item] = true
+		}
+	}
+
+	return set
+}
+
+// Initializes the filter from the environment, where an unset EVENT_SOURCES defaults to every service the Auditor
+// handles and an empty EVENT_NAMES allows every event name but those of resource policy services the Auditor ignores.
+func newFilter() *filter {
+	eventSources, ok := os.LookupEnv("EVENT_SOURCES")
+	if !ok {
+		eventSources = defaultEventSources
+	}
+	eventNames := parseSet(os.Getenv("EVENT_NAMES"))
+	var sourceEventNames map[string]map[string]bool
+	if len(eventNames) == 0 {
+		sourceEventNames = defaultSourceEventNames
+	}
+
+	return &filter{
+		EventSources:     parseSet(eventSources),
+		EventNames:       eventNames,
+		SourceEventNames: sourceEventNames,
+		SkipReadOnly:     os.Getenv("INCLUDE_READ_ONLY") != "true",
+		SkipErrorCode:    os.Getenv("INCLUDE_ERRORS") != "true",
+	}
+}
+
+// Whether the CloudTrail event should be sent to the Auditor.
+func (f *filter) allows(cloudTrailEvt *cloudtrail.CloudTrailEvent) bool {
+	if len(f.EventSources) > 0 && !f.EventSources[cloudTrailEvt.EventSource] {
+		return false
+	}
+	if len(f.EventNames) > 0 && !f.EventNames[cloudTrailEvt.EventName] {
+		return false
+	}
+	eventNames, ok := f.SourceEventNames[cloudTrailEvt.EventSource]
+	if ok && !eventNames[apiVersionPattern.ReplaceAllString(cloudTrailEvt.EventName, "")] {
+		return false
+	}
+	if f.SkipReadOnly && cloudTrailEvt.ReadOnly {
+		return false
+	}
+	if f.SkipErrorCode && cloudTrailEvt.ErrorCode != "" {
+		return false
+	}
+
+	return true
+}
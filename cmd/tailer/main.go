@@ -1,116 +1,381 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/dlabey/iam-git-auditor/pkg/cloudtrail"
+	"github.com/dlabey/iam-git-auditor/pkg/queue"
 	"github.com/dlabey/iam-git-auditor/pkg/utils"
+	"io"
 	"log"
 	"os"
-	"sync"
-	"sync/atomic"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudtrail/cloudtrailiface"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+
+	awscloudtrail "github.com/aws/aws-sdk-go/service/cloudtrail"
 )
 
+// The detail-type of the CloudTrail events EventBridge delivers.
+const eventBridgeDetailType = "AWS API Call via CloudTrail"
+
+// The first two bytes of any gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+type objectResponse struct {
+	Key          string   `json:"Key"`
+	Successful   int32    `json:"Successful"`
+	Failed       int32    `json:"Failed"`
+	Filtered     int32    `json:"Filtered"`
+	LostEventIDs []string `json:"LostEventIDs,omitempty"`
+	Validation   string   `json:"Validation,omitempty"`
+	Rejected     bool     `json:"Rejected,omitempty"`
+}
+
 type response struct {
-	Successful int32 `json:"Successful"`
-	Failed     int32 `json:"Failed"`
+	Successful   int32             `json:"Successful"`
+	Failed       int32             `json:"Failed"`
+	Filtered     int32             `json:"Filtered"`
+	FailedKeys   []string          `json:"FailedKeys,omitempty"`
+	RejectedKeys []string          `json:"RejectedKeys,omitempty"`
+	LostEventIDs []string          `json:"LostEventIDs,omitempty"`
+	Objects      []*objectResponse `json:"Objects"`
 }
 
-// Tails CloudTrail events into an SQS queue for synchronous processing to Git.
-func Tailer(ctx context.Context, s3Evt events.S3Event, s3Svc s3iface.S3API, sqsSvc sqsiface.SQSAPI) (*response, error) {
+// Returns a reader of the decompressed S3 object body. CloudTrail delivers its log files gzipped, but whether the
+// object is gzipped is decided by its magic bytes since the HTTP transport may already have decoded it.
+func decompress(getObjectOutput *s3.GetObjectOutput, key string) (io.Reader, error) {
+	body := bufio.NewReader(getObjectOutput.Body)
+	magic, err := body.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.Equal(magic, gzipMagic) {
+		log.Printf("msg=\"Decompressing S3 object\" key=\"%s\"", key)
+
+		return gzip.NewReader(body)
+	}
+
+	// Flag objects that advertise gzip but are not.
+	contentEncoding := aws.StringValue(getObjectOutput.ContentEncoding)
+	if contentEncoding == "gzip" || strings.HasSuffix(key, ".gz") {
+		log.Printf("msg=\"S3 object is not gzip encoded, reading as is\" key=\"%s\" contentEncoding=\"%s\"", key,
+			contentEncoding)
+	}
+
+	return body, nil
+}
+
+// Advances the decoder into the Records array of a CloudTrail log file, skipping any other field. A log file without
+// Records leaves the decoder at its end.
+func seekRecords(decoder *json.Decoder) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != json.Delim('{') {
+		return fmt.Errorf("expected a CloudTrail log file object but got %v", token)
+	}
+	for decoder.More() {
+		token, err = decoder.Token()
+		if err != nil {
+			return err
+		}
+		if token == "Records" {
+			token, err = decoder.Token()
+			if err != nil {
+				return err
+			}
+			if token != json.Delim('[') {
+				return fmt.Errorf("expected a Records array but got %v", token)
+			}
+
+			return nil
+		}
+		var skip json.RawMessage
+		err = decoder.Decode(&skip)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Whether decoding a CloudTrail event only failed on a value with another type than the field it decodes into, in which
+// case the rest of the event is still decoded. Services name their request parameters alike but not always with the
+// same type, and the fields are modeled after the services the Auditor handles.
+func isTypeMismatch(err error) bool {
+	_, ok := err.(*json.UnmarshalTypeError)
+
+	return ok
+}
+
+// Reads the CloudTrail events of a single S3 log object and sends them to the SQS queue. A log object covered by a
+// digest file is validated as read, and only sent once validated.
+func tailObject(bucket string, key string, digestEntry *digestEntry, filter *filter, validator *validator,
+	s3Svc s3iface.S3API, sqsSvc sqsiface.SQSAPI) (*objectResponse, error) {
+	// Attach where the log object comes from to each of its events.
+	attributes := map[string]*sqs.MessageAttributeValue{
+		queue.LogObjectAttribute: {
+			DataType:    aws.String("String"),
+			StringValue: aws.String("s3://" + bucket + "/" + key),
+		},
+	}
+	logFileKey, err := cloudtrail.ParseLogFileKey(key)
+	if err != nil {
+		log.Printf("msg=\"Error parsing CloudTrail key\" key=\"%s\" err=\"%s\"", key, err)
+	} else {
+		attributes[queue.AccountIDAttribute] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(logFileKey.AccountID),
+		}
+		attributes[queue.RegionAttribute] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(logFileKey.Region),
+		}
+	}
+
+	// Validate the log object against its digest file, which then travels with each of its events. The log object is
+	// hashed as it is read, then read again for its events pinned to the ETag hashed, so that the events sent are the
+	// ones that were validated without holding the log object whole.
+	var validation string
+	var eTag *string
+	if digestEntry != nil {
+		validation, eTag, err = validator.validateLogFile(bucket, key, digestEntry)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("msg=\"Validated S3 object\" key=\"%s\" validation=\"%s\"", key, validation)
+		if validation != queue.LogFileVerified && validator.mode == validationReject {
+			return &objectResponse{
+				Key:        key,
+				Validation: validation,
+				Rejected:   true,
+			}, nil
+		}
+		attributes[queue.LogFileValidationAttribute] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(validation),
+		}
+	}
+
 	// Get the S3 compressed log object.
 	getObjectOutput, err := s3Svc.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(s3Evt.Records[0].S3.Bucket.Name),
-		Key:    aws.String(s3Evt.Records[0].S3.Object.Key),
+		Bucket:  aws.String(bucket),
+		IfMatch: eTag,
+		Key:     aws.String(key),
 	})
-	utils.CheckError(err, "msg=\"Error getting S3 object\" err=\"%s\"")
-	var buf bytes.Buffer
-	_, err = buf.ReadFrom(getObjectOutput.Body)
-	utils.CheckError(err, "msg=\"Error buffering S3 object\" err=\"%s\"")
-
-	// Unmarshal the CloudTrail events.
-	var cloudTrailEvt cloudtrail.CloudTrailEvents
-	err = json.Unmarshal(buf.Bytes(), &cloudTrailEvt)
-	utils.CheckError(err, "msg=\"Error unmarshalling CloudTrail events\" err=\"%s\"")
-
-	// Partition the CloudTrail event records into partitions of up to 10.
-	records := cloudTrailEvt.Records
-	partitionSize := 10
-	var partitions [][]cloudtrail.CloudTrailEvent
-	for partitionSize < len(cloudTrailEvt.Records) {
-		records, partitions = records[partitionSize:], append(partitions, records[0:partitionSize:partitionSize])
-	}
-	partitions = append(partitions, records)
-
-	// Concurrently go over each partition and batch it to the SQS queue.
-	partitionsLen := len(partitions)
-	log.Printf("msg=\"Processesing partitions\" partitionsLen=%d", partitionsLen)
-	var waitGroup sync.WaitGroup
-	waitGroup.Add(partitionsLen)
-	var successful int32
-	var failed int32
-	for i := 0; i < len(partitions); i++ {
-		go func(i int) {
-			log.Printf("msg=\"Processesing partition\" partitionIdx=%d", i)
-
-			// Close the channel when complete.
-			defer waitGroup.Done()
-
-			// Initialize an array of SendMessageBatchRequestEntry.
-			entries := make([]*sqs.SendMessageBatchRequestEntry, len(partitions[i]))
-
-			// Go over each partition and prepare it for the request.
-			for j := 0; j < len(partitions[i]); j++ {
-				messageBody, err := json.Marshal(partitions[i][j])
-				utils.CheckError(err, "msg=\"Error marshalling CloudTrailEvent\" err=\"%s\"")
-				entries[j] = &sqs.SendMessageBatchRequestEntry{
-					DelaySeconds: aws.Int64(10),
-					Id:           aws.String(partitions[i][j].EventID),
-					MessageBody:  aws.String(string(messageBody)),
-				}
+	if err != nil {
+		return nil, err
+	}
+	defer getObjectOutput.Body.Close()
+	body, err := decompress(getObjectOutput, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stream decode the CloudTrail events, sending them to the SQS queue as they are parsed.
+	enqueuer := newEnqueuer(attributes, s3Svc, sqsSvc)
+	var filtered int32
+	decoder := json.NewDecoder(body)
+	err = seekRecords(decoder)
+	for err == nil && decoder.More() {
+		var record cloudtrail.CloudTrailEvent
+		err = decoder.Decode(&record)
+		if isTypeMismatch(err) {
+			log.Printf("msg=\"Skipped mismatched CloudTrail event value\" eventId=\"%s\" err=\"%s\"", record.EventID, err)
+			err = nil
+		}
+		if err != nil {
+			break
+		}
+
+		// Filter out the CloudTrail event records the Auditor has no use for.
+		if filter.allows(&record) {
+			enqueuer.add(record)
+		} else {
+			filtered++
+		}
+	}
+	successful, lost := enqueuer.wait()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("msg=\"Filtered CloudTrail events\" key=\"%s\" filtered=%d", key, filtered)
+
+	return &objectResponse{
+		Key:          key,
+		Successful:   successful,
+		Failed:       int32(len(lost)),
+		Filtered:     filtered,
+		LostEventIDs: lost,
+		Validation:   validation,
+	}, nil
+}
+
+// Adds the result of tailing an S3 object. Only an S3 object that could not be read fails the invocation, since Lambda
+// retrying it tails the whole notification again, whereas events given up on after every attempt are only reported.
+func (r *response) addObject(bucket string, key string, objectResponse *objectResponse, err error) {
+	if err != nil {
+		log.Printf("msg=\"Error tailing S3 object\" bucket=\"%s\" key=\"%s\" err=\"%s\"", bucket, key, err)
+		r.FailedKeys = append(r.FailedKeys, key)
+
+		return
+	}
+	r.Objects = append(r.Objects, objectResponse)
+	if objectResponse.Rejected {
+		log.Printf("msg=\"Rejected S3 object\" key=\"%s\" validation=\"%s\"", key, objectResponse.Validation)
+		r.RejectedKeys = append(r.RejectedKeys, key)
+
+		return
+	}
+	log.Printf("msg=\"Tailed S3 object\" key=\"%s\" successful=%d failed=%d filtered=%d", key,
+		objectResponse.Successful, objectResponse.Failed, objectResponse.Filtered)
+	r.Successful += objectResponse.Successful
+	r.Failed += objectResponse.Failed
+	r.Filtered += objectResponse.Filtered
+	r.LostEventIDs = append(r.LostEventIDs, objectResponse.LostEventIDs...)
+}
+
+// Tails CloudTrail events into an SQS queue for synchronous processing to Git.
+func Tailer(ctx context.Context, s3Evt events.S3Event, s3Svc s3iface.S3API, sqsSvc sqsiface.SQSAPI,
+	cloudTrailSvc cloudtrailiface.CloudTrailAPI) (*response, error) {
+	// Initialize the result.
+	response := &response{}
+
+	// Initialize the event filter.
+	filter := newFilter()
+
+	// Initialize the log file validator.
+	validator, err := newValidator(s3Svc, cloudTrailSvc)
+	if err != nil {
+		return response, err
+	}
+
+	// Go over every S3 object in the notification since S3 may batch several of them.
+	for i := 0; i < len(s3Evt.Records); i++ {
+		bucket := s3Evt.Records[i].S3.Bucket.Name
+		key := s3Evt.Records[i].S3.Object.Key
+
+		// Log files to validate are tailed once the digest file covering them is delivered, up to an hour later.
+		if isDigestKey(key) != (validator.mode != validationOff) {
+			log.Printf("msg=\"Skipped S3 object\" key=\"%s\" validation=\"%s\"", key, validator.mode)
+			if validator.mode != validationOff {
+				validator.checkDigestDelivery(bucket, key)
 			}
+			continue
+		}
+		if validator.mode == validationOff {
+			objectResponse, err := tailObject(bucket, key, nil, filter, validator, s3Svc, sqsSvc)
+			response.addObject(bucket, key, objectResponse, err)
+			continue
+		}
 
-			// Send the message to the SQS queue to be processed synchronously for Git.
-			queueUrl := os.Getenv("QUEUE_URL")
-			sendMessageBatchOutput, err := sqsSvc.SendMessageBatch(&sqs.SendMessageBatchInput{
-				Entries:  entries,
-				QueueUrl: aws.String(queueUrl),
-			})
-			utils.CheckError(err, "msg=\"Error sending SQS message\" err=\"%s\"")
-			log.Printf("msg=\"Sent message batch to SQS\" entriesLen=%d", len(entries))
+		// Validate the digest file and tail each of the log files it covers.
+		digest, digestValidation, err := validator.validateDigest(bucket, key)
+		if err != nil {
+			log.Printf("msg=\"Error reading digest file\" bucket=\"%s\" key=\"%s\" err=\"%s\"", bucket, key, err)
+			response.FailedKeys = append(response.FailedKeys, key)
+			continue
+		}
+		log.Printf("msg=\"Validated digest file\" key=\"%s\" validation=\"%s\" logFilesLen=%d", key, digestValidation,
+			len(digest.LogFiles))
+		for j := 0; j < len(digest.LogFiles); j++ {
+			logFile := &digest.LogFiles[j]
+			objectResponse, err := tailObject(logFile.S3Bucket, logFile.S3Object, &digestEntry{
+				logFile:    logFile,
+				validation: digestValidation,
+			}, filter, validator, s3Svc, sqsSvc)
+			response.addObject(logFile.S3Bucket, logFile.S3Object, objectResponse, err)
+		}
+	}
 
-			// Evaluate the response.
-			atomic.AddInt32(&successful, int32(len(sendMessageBatchOutput.Successful)))
-			atomic.AddInt32(&failed, int32(len(sendMessageBatchOutput.Failed)))
-		}(i)
+	// If there is an error, use the result JSON as the error message. Rejected log files are left out since they would
+	// be rejected again, while the log files covered by the same digest file would be sent again.
+	if len(response.FailedKeys) > 0 {
+		errJson, jsonErr := json.Marshal(response)
+		utils.CheckError(jsonErr, "msg=\"Error marshalling result\" err=\"%s\"")
+		err = errors.New(string(errJson))
 	}
-	waitGroup.Wait()
 
+	log.Printf("msg=\"Response\" successful=%d failed=%d filtered=%d failedKeys=\"%s\" rejectedKeys=\"%s\" "+
+		"lostEventIds=\"%s\"", response.Successful, response.Failed, response.Filtered,
+		strings.Join(response.FailedKeys, ","), strings.Join(response.RejectedKeys, ","),
+		strings.Join(response.LostEventIDs, ","))
+
+	return response, err
+}
+
+// Tails a CloudTrail event delivered by EventBridge into the SQS queue, which is near real time compared to waiting
+// for CloudTrail to deliver its log files to S3.
+func EventBridgeTailer(ctx context.Context, evt events.CloudWatchEvent, s3Svc s3iface.S3API,
+	sqsSvc sqsiface.SQSAPI) (*response, error) {
 	// Initialize the result.
-	response := &response{
-		Successful: successful,
-		Failed:     failed,
+	response := &response{}
+
+	// Unwrap the CloudTrail event.
+	if evt.DetailType != eventBridgeDetailType {
+		log.Printf("msg=\"EventBridge event is not a CloudTrail API call\" detailType=\"%s\"", evt.DetailType)
+		response.Filtered++
+
+		return response, nil
+	}
+	var record cloudtrail.CloudTrailEvent
+	err := json.Unmarshal(evt.Detail, &record)
+	if isTypeMismatch(err) {
+		log.Printf("msg=\"Skipped mismatched CloudTrail event value\" eventId=\"%s\" err=\"%s\"", record.EventID, err)
+		err = nil
+	}
+	if err != nil {
+		return response, err
+	}
+	log.Printf("msg=\"Tailing EventBridge event\" eventId=\"%s\" eventName=%s", record.EventID, record.EventName)
+
+	// Filter out the CloudTrail event if the Auditor has no use for it.
+	if !newFilter().allows(&record) {
+		response.Filtered++
+
+		return response, nil
 	}
 
+	// Send the CloudTrail event to the SQS queue along with where it comes from.
+	enqueuer := newEnqueuer(map[string]*sqs.MessageAttributeValue{
+		queue.AccountIDAttribute: {
+			DataType:    aws.String("String"),
+			StringValue: aws.String(evt.AccountID),
+		},
+		queue.RegionAttribute: {
+			DataType:    aws.String("String"),
+			StringValue: aws.String(evt.Region),
+		},
+	}, s3Svc, sqsSvc)
+	enqueuer.add(record)
+	response.Successful, response.LostEventIDs = enqueuer.wait()
+	response.Failed = int32(len(response.LostEventIDs))
+
 	// If there is an error, use the result JSON as the error message.
-	if failed > 0 {
-		errJson, err := json.Marshal(response)
-		utils.CheckError(err, "msg=\"Error marshalling result\" err=\"%s\"")
+	if response.Failed > 0 {
+		errJson, jsonErr := json.Marshal(response)
+		utils.CheckError(jsonErr, "msg=\"Error marshalling result\" err=\"%s\"")
 		err = errors.New(string(errJson))
 	}
 
-	log.Printf("msg=\"Response\" successful=%d failed=%d", response.Successful, response.Failed)
+	log.Printf("msg=\"Response\" successful=%d failed=%d filtered=%d", response.Successful, response.Failed,
+		response.Filtered)
 
 	return response, err
 }
@@ -125,9 +390,32 @@ func handler(ctx context.Context, s3Evt events.S3Event) (*response, error) {
 	// Initialize the SQS service.
 	sqsSvc := sqs.New(sess)
 
-	return Tailer(ctx, s3Evt, s3Svc, sqsSvc)
+	// Initialize the CloudTrail service.
+	cloudTrailSvc := awscloudtrail.New(sess)
+
+	return Tailer(ctx, s3Evt, s3Svc, sqsSvc, cloudTrailSvc)
+}
+
+func eventBridgeHandler(ctx context.Context, evt events.CloudWatchEvent) (*response, error) {
+	// Initialize an AWS session.
+	sess := session.Must(session.NewSession())
+
+	// Initialize the S3 service.
+	s3Svc := s3.New(sess)
+
+	// Initialize the SQS service.
+	sqsSvc := sqs.New(sess)
+
+	return EventBridgeTailer(ctx, evt, s3Svc, sqsSvc)
 }
 
 func main() {
-	lambda.Start(handler)
+	if os.Getenv("TAILER_SOURCE") == "eventbridge" {
+		lambda.Start(eventBridgeHandler)
+	} else {
+		// Fail at startup rather than on every S3 notification.
+		_, err := validationMode()
+		utils.CheckError(err, "msg=\"Invalid log file validation\" err=\"%s\"")
+		lambda.Start(handler)
+	}
 }
@@ -2,16 +2,32 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/dlabey/iam-git-auditor/pkg/cloudtrail"
+	"github.com/dlabey/iam-git-auditor/pkg/queue"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	awscloudtrail "github.com/aws/aws-sdk-go/service/cloudtrail"
 )
 
 type MockS3Svc struct {
@@ -25,6 +41,18 @@ func (m *MockS3Svc) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, er
 	return args.Get(0).(*s3.GetObjectOutput), args.Error(1)
 }
 
+func (m *MockS3Svc) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	args := m.Called(input)
+
+	return args.Get(0).(*s3.PutObjectOutput), args.Error(1)
+}
+
+func (m *MockS3Svc) ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	args := m.Called(input)
+
+	return args.Get(0).(*s3.ListObjectsV2Output), args.Error(1)
+}
+
 type MockSQSSvc struct {
 	sqs.SQS
 	mock.Mock
@@ -36,6 +64,18 @@ func (m *MockSQSSvc) SendMessageBatch(input *sqs.SendMessageBatchInput) (*sqs.Se
 	return args.Get(0).(*sqs.SendMessageBatchOutput), args.Error(1)
 }
 
+type MockCloudTrailSvc struct {
+	awscloudtrail.CloudTrail
+	mock.Mock
+}
+
+func (m *MockCloudTrailSvc) ListPublicKeys(input *awscloudtrail.ListPublicKeysInput) (
+	*awscloudtrail.ListPublicKeysOutput, error) {
+	args := m.Called(input)
+
+	return args.Get(0).(*awscloudtrail.ListPublicKeysOutput), args.Error(1)
+}
+
 func TestTailer(t *testing.T) {
 	ctx := new(context.Context)
 
@@ -53,7 +93,8 @@ func TestTailer(t *testing.T) {
 	}
 
 	cloudTrailEvt1 := cloudtrail.CloudTrailEvent{
-		EventName: "CreatePolicy",
+		EventSource: "iam.amazonaws.com",
+		EventName:   "CreatePolicy",
 		RequestParameters: cloudtrail.RequestParameters{
 			PolicyName:     "policyName",
 			PolicyDocument: "policyDocument",
@@ -61,7 +102,8 @@ func TestTailer(t *testing.T) {
 		EventTime: "2012-11-01T22:08:41+00:00",
 	}
 	cloudTrailEvt2 := cloudtrail.CloudTrailEvent{
-		EventName: "DeletePolicy",
+		EventSource: "iam.amazonaws.com",
+		EventName:   "DeletePolicy",
 		RequestParameters: cloudtrail.RequestParameters{
 			PolicyName:     "policyName",
 			PolicyDocument: "policyDocument",
@@ -69,7 +111,8 @@ func TestTailer(t *testing.T) {
 		EventTime: "2012-11-01T22:09:41+00:00",
 	}
 	cloudTrailEvt3 := cloudtrail.CloudTrailEvent{
-		EventName: "DeleteRolePermissionsBoundary",
+		EventSource: "iam.amazonaws.com",
+		EventName:   "DeleteRolePermissionsBoundary",
 		RequestParameters: cloudtrail.RequestParameters{
 			RoleName: "roleName",
 		},
@@ -99,7 +142,1057 @@ func TestTailer(t *testing.T) {
 			Failed:     []*sqs.BatchResultErrorEntry{},
 		}, nil)
 
-	response, err := Tailer(*ctx, s3Evt, s3SvcMock, sqsSvcMock)
+	response, err := Tailer(*ctx, s3Evt, s3SvcMock, sqsSvcMock, new(MockCloudTrailSvc))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), response.Successful)
+}
+
+func TestTailerGzip(t *testing.T) {
+	ctx := new(context.Context)
+
+	s3Evt := events.S3Event{
+		Records: []events.S3EventRecord{{
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{
+					Name: "test",
+				},
+				Object: events.S3Object{
+					Key: "AWSLogs/123456789012/CloudTrail/us-east-1/2012/11/01/test.json.gz",
+				},
+			},
+		}},
+	}
+
+	cloudTrailEvts := cloudtrail.CloudTrailEvents{
+		Records: []cloudtrail.CloudTrailEvent{{
+			EventID:     "1",
+			EventSource: "iam.amazonaws.com",
+			EventName:   "CreatePolicy",
+			RequestParameters: cloudtrail.RequestParameters{
+				PolicyName:     "policyName",
+				PolicyDocument: "policyDocument",
+			},
+			EventTime: "2012-11-01T22:08:41+00:00",
+		}, {
+			EventID:     "2",
+			EventSource: "iam.amazonaws.com",
+			EventName:   "DeletePolicy",
+			RequestParameters: cloudtrail.RequestParameters{
+				PolicyName: "policyName",
+			},
+			EventTime: "2012-11-01T22:09:41+00:00",
+		}},
+	}
+
+	cloudTrailEvtsJson, _ := json.Marshal(cloudTrailEvts)
+	var gzipped bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipped)
+	_, _ = gzipWriter.Write(cloudTrailEvtsJson)
+	_ = gzipWriter.Close()
+	body := ioutil.NopCloser(&gzipped)
+
+	s3SvcMock := new(MockS3Svc)
+	s3SvcMock.On("GetObject", mock.AnythingOfType("*s3.GetObjectInput")).Return(
+		&s3.GetObjectOutput{
+			Body:            body,
+			ContentEncoding: aws.String("gzip"),
+		}, nil)
+
+	sqsSvcMock := new(MockSQSSvc)
+	sqsSvcMock.On("SendMessageBatch", mock.AnythingOfType("*sqs.SendMessageBatchInput")).Return(
+		&sqs.SendMessageBatchOutput{
+			Successful: []*sqs.SendMessageBatchResultEntry{{}, {}},
+			Failed:     []*sqs.BatchResultErrorEntry{},
+		}, nil)
+
+	response, err := Tailer(*ctx, s3Evt, s3SvcMock, sqsSvcMock, new(MockCloudTrailSvc))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), response.Successful)
+	sendMessageBatchInput := sqsSvcMock.Calls[0].Arguments.Get(0).(*sqs.SendMessageBatchInput)
+	assert.Len(t, sendMessageBatchInput.Entries, 2)
+}
+
+func TestTailerMultipleObjects(t *testing.T) {
+	ctx := new(context.Context)
+
+	s3Evt := events.S3Event{
+		Records: []events.S3EventRecord{{
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{
+					Name: "test",
+				},
+				Object: events.S3Object{
+					Key: "test1",
+				},
+			},
+		}, {
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{
+					Name: "test",
+				},
+				Object: events.S3Object{
+					Key: "test2",
+				},
+			},
+		}, {
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{
+					Name: "test",
+				},
+				Object: events.S3Object{
+					Key: "test3",
+				},
+			},
+		}},
+	}
+
+	cloudTrailEvts := cloudtrail.CloudTrailEvents{
+		Records: []cloudtrail.CloudTrailEvent{{
+			EventSource: "iam.amazonaws.com",
+			EventName:   "CreatePolicy",
+			RequestParameters: cloudtrail.RequestParameters{
+				PolicyName:     "policyName",
+				PolicyDocument: "policyDocument",
+			},
+			EventTime: "2012-11-01T22:08:41+00:00",
+		}},
+	}
+	cloudTrailEvtsJson, _ := json.Marshal(cloudTrailEvts)
+
+	s3SvcMock := new(MockS3Svc)
+	s3SvcMock.On("GetObject", mock.MatchedBy(func(input *s3.GetObjectInput) bool {
+		return *input.Key == "test1"
+	})).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewBuffer(cloudTrailEvtsJson)),
+	}, nil)
+	s3SvcMock.On("GetObject", mock.MatchedBy(func(input *s3.GetObjectInput) bool {
+		return *input.Key == "test2"
+	})).Return((*s3.GetObjectOutput)(nil), errors.New("NoSuchKey"))
+	s3SvcMock.On("GetObject", mock.MatchedBy(func(input *s3.GetObjectInput) bool {
+		return *input.Key == "test3"
+	})).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewBuffer(cloudTrailEvtsJson)),
+	}, nil)
+
+	sqsSvcMock := new(MockSQSSvc)
+	sqsSvcMock.On("SendMessageBatch", mock.AnythingOfType("*sqs.SendMessageBatchInput")).Return(
+		&sqs.SendMessageBatchOutput{
+			Successful: []*sqs.SendMessageBatchResultEntry{{}},
+			Failed:     []*sqs.BatchResultErrorEntry{},
+		}, nil)
+
+	response, err := Tailer(*ctx, s3Evt, s3SvcMock, sqsSvcMock, new(MockCloudTrailSvc))
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(2), response.Successful)
+	assert.Equal(t, int32(0), response.Failed)
+	assert.Equal(t, []string{"test2"}, response.FailedKeys)
+	assert.Len(t, response.Objects, 2)
+	sqsSvcMock.AssertNumberOfCalls(t, "SendMessageBatch", 2)
+}
+
+func TestTailerFilter(t *testing.T) {
+	ctx := new(context.Context)
+
+	s3Evt := events.S3Event{
+		Records: []events.S3EventRecord{{
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{
+					Name: "test",
+				},
+				Object: events.S3Object{
+					Key: "test",
+				},
+			},
+		}},
+	}
+
+	cloudTrailEvts := cloudtrail.CloudTrailEvents{
+		Records: []cloudtrail.CloudTrailEvent{{
+			EventID:     "1",
+			EventSource: "iam.amazonaws.com",
+			EventName:   "CreatePolicy",
+			EventTime:   "2012-11-01T22:08:41+00:00",
+		}, {
+			EventID:     "2",
+			EventSource: "s3.amazonaws.com",
+			EventName:   "GetObject",
+			EventTime:   "2012-11-01T22:09:41+00:00",
+			ReadOnly:    true,
+		}, {
+			EventID:     "3",
+			EventSource: "iam.amazonaws.com",
+			EventName:   "GetRole",
+			EventTime:   "2012-11-01T22:10:41+00:00",
+			ReadOnly:    true,
+		}, {
+			EventID:     "4",
+			EventSource: "iam.amazonaws.com",
+			EventName:   "CreateRole",
+			EventTime:   "2012-11-01T22:11:41+00:00",
+			ErrorCode:   "AccessDenied",
+		}, {
+			EventID:     "5",
+			EventSource: "s3.amazonaws.com",
+			EventName:   "PutObject",
+			EventTime:   "2012-11-01T22:12:41+00:00",
+		}, {
+			EventID:     "6",
+			EventSource: "s3.amazonaws.com",
+			EventName:   "PutBucketPolicy",
+			EventTime:   "2012-11-01T22:13:41+00:00",
+		}, {
+			EventID:     "7",
+			EventSource: "lambda.amazonaws.com",
+			EventName:   "AddPermission20150331v2",
+			EventTime:   "2012-11-01T22:14:41+00:00",
+		}},
+	}
+	cloudTrailEvtsJson, _ := json.Marshal(cloudTrailEvts)
+
+	s3SvcMock := new(MockS3Svc)
+	s3SvcMock.On("GetObject", mock.AnythingOfType("*s3.GetObjectInput")).Return(
+		&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewBuffer(cloudTrailEvtsJson)),
+		}, nil)
+
+	sqsSvcMock := new(MockSQSSvc)
+	sqsSvcMock.On("SendMessageBatch", mock.AnythingOfType("*sqs.SendMessageBatchInput")).Return(
+		&sqs.SendMessageBatchOutput{
+			Successful: []*sqs.SendMessageBatchResultEntry{{}, {}, {}},
+			Failed:     []*sqs.BatchResultErrorEntry{},
+		}, nil)
+
+	response, err := Tailer(*ctx, s3Evt, s3SvcMock, sqsSvcMock, new(MockCloudTrailSvc))
 	assert.Nil(t, err)
 	assert.Equal(t, int32(3), response.Successful)
+	assert.Equal(t, int32(4), response.Filtered)
+	sendMessageBatchInput := sqsSvcMock.Calls[0].Arguments.Get(0).(*sqs.SendMessageBatchInput)
+	assert.Len(t, sendMessageBatchInput.Entries, 3)
+	assert.Contains(t, *sendMessageBatchInput.Entries[0].MessageBody, "CreatePolicy")
+	assert.Contains(t, *sendMessageBatchInput.Entries[1].MessageBody, "PutBucketPolicy")
+	assert.Contains(t, *sendMessageBatchInput.Entries[2].MessageBody, "AddPermission20150331v2")
+}
+
+func TestTailerFifo(t *testing.T) {
+	ctx := new(context.Context)
+
+	os.Setenv("QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/123456789012/iam-audit.fifo")
+	defer os.Unsetenv("QUEUE_URL")
+
+	s3Evt := events.S3Event{
+		Records: []events.S3EventRecord{{
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{
+					Name: "test",
+				},
+				Object: events.S3Object{
+					Key: "test",
+				},
+			},
+		}},
+	}
+
+	cloudTrailEvts := cloudtrail.CloudTrailEvents{
+		Records: []cloudtrail.CloudTrailEvent{{
+			EventID:            "2",
+			EventSource:        "iam.amazonaws.com",
+			EventName:          "CreatePolicyVersion",
+			EventTime:          "2012-11-01T22:09:41Z",
+			RecipientAccountID: "123456789012",
+			RequestParameters: cloudtrail.RequestParameters{
+				PolicyArn: "arn:aws:iam::123456789012:policy/policyName",
+			},
+		}, {
+			EventID:            "3",
+			EventSource:        "iam.amazonaws.com",
+			EventName:          "CreateRole",
+			EventTime:          "2012-11-01T22:10:41Z",
+			RecipientAccountID: "123456789012",
+			RequestParameters: cloudtrail.RequestParameters{
+				RoleName: "roleName",
+			},
+		}, {
+			EventID:            "1",
+			EventSource:        "iam.amazonaws.com",
+			EventName:          "CreatePolicy",
+			EventTime:          "2012-11-01T22:08:41Z",
+			RecipientAccountID: "123456789012",
+			RequestParameters: cloudtrail.RequestParameters{
+				PolicyName: "policyName",
+			},
+		}},
+	}
+	cloudTrailEvtsJson, _ := json.Marshal(cloudTrailEvts)
+
+	s3SvcMock := new(MockS3Svc)
+	s3SvcMock.On("GetObject", mock.AnythingOfType("*s3.GetObjectInput")).Return(
+		&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewBuffer(cloudTrailEvtsJson)),
+		}, nil)
+
+	sqsSvcMock := new(MockSQSSvc)
+	sqsSvcMock.On("SendMessageBatch", mock.MatchedBy(func(input *sqs.SendMessageBatchInput) bool {
+		return len(input.Entries) == 2
+	})).Return(&sqs.SendMessageBatchOutput{
+		Successful: []*sqs.SendMessageBatchResultEntry{{}, {}},
+	}, nil)
+	sqsSvcMock.On("SendMessageBatch", mock.MatchedBy(func(input *sqs.SendMessageBatchInput) bool {
+		return len(input.Entries) == 1
+	})).Return(&sqs.SendMessageBatchOutput{
+		Successful: []*sqs.SendMessageBatchResultEntry{{}},
+	}, nil)
+
+	response, err := Tailer(*ctx, s3Evt, s3SvcMock, sqsSvcMock, new(MockCloudTrailSvc))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), response.Successful)
+	sqsSvcMock.AssertNumberOfCalls(t, "SendMessageBatch", 2)
+	for _, call := range sqsSvcMock.Calls {
+		sendMessageBatchInput := call.Arguments.Get(0).(*sqs.SendMessageBatchInput)
+		for _, entry := range sendMessageBatchInput.Entries {
+			assert.Nil(t, entry.DelaySeconds)
+			assert.Equal(t, *entry.Id, *entry.MessageDeduplicationId)
+		}
+		if len(sendMessageBatchInput.Entries) == 2 {
+			assert.Equal(t, "1", *sendMessageBatchInput.Entries[0].Id)
+			assert.Equal(t, "2", *sendMessageBatchInput.Entries[1].Id)
+			assert.Equal(t, "123456789012/policy/policyName", *sendMessageBatchInput.Entries[0].MessageGroupId)
+			assert.Equal(t, "123456789012/policy/policyName", *sendMessageBatchInput.Entries[1].MessageGroupId)
+		} else {
+			assert.Equal(t, "123456789012/role/roleName", *sendMessageBatchInput.Entries[0].MessageGroupId)
+		}
+	}
+}
+
+func TestEnqueuerFifoFlush(t *testing.T) {
+	os.Setenv("QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/123456789012/iam-audit.fifo")
+	defer os.Unsetenv("QUEUE_URL")
+
+	sqsSvcMock := new(MockSQSSvc)
+	sqsSvcMock.On("SendMessageBatch", mock.AnythingOfType("*sqs.SendMessageBatchInput")).Return(
+		&sqs.SendMessageBatchOutput{
+			Successful: []*sqs.SendMessageBatchResultEntry{{}},
+		}, nil)
+
+	// A message group is sent once its partition is full.
+	enqueuer := newEnqueuer(nil, new(MockS3Svc), sqsSvcMock)
+	for i := 0; i <= partitionSize; i++ {
+		enqueuer.add(cloudtrail.CloudTrailEvent{
+			EventID:            strconv.Itoa(i),
+			EventTime:          "2012-11-01T22:08:41Z",
+			RecipientAccountID: "123456789012",
+			RequestParameters:  cloudtrail.RequestParameters{RoleName: "roleName"},
+		})
+	}
+	assert.Len(t, enqueuer.groupPending["123456789012/role/roleName"], 1)
+
+	// Every message group is sent once too many records are held back.
+	for i := 1; i < maxPendingMessages; i++ {
+		enqueuer.add(cloudtrail.CloudTrailEvent{
+			EventID:            "user" + strconv.Itoa(i),
+			EventTime:          "2012-11-01T22:09:41Z",
+			RecipientAccountID: "123456789012",
+			RequestParameters:  cloudtrail.RequestParameters{UserName: "userName" + strconv.Itoa(i)},
+		})
+	}
+	assert.Empty(t, enqueuer.groupPending)
+	enqueuer.wait()
+	sqsSvcMock.AssertNumberOfCalls(t, "SendMessageBatch", maxPendingMessages+1)
+	var entriesLens []int
+	for _, call := range sqsSvcMock.Calls {
+		entriesLens = append(entriesLens, len(call.Arguments.Get(0).(*sqs.SendMessageBatchInput).Entries))
+	}
+	assert.Contains(t, entriesLens, partitionSize)
+}
+
+func TestEnqueuerFifoDuplicates(t *testing.T) {
+	defer os.Unsetenv("QUEUE_URL")
+
+	// SQS rejects a batch with a repeated Id whatever the queue type.
+	for _, queueUrl := range []string{
+		"https://sqs.us-east-1.amazonaws.com/123456789012/iam-audit.fifo",
+		"https://sqs.us-east-1.amazonaws.com/123456789012/iam-audit",
+	} {
+		os.Setenv("QUEUE_URL", queueUrl)
+		sqsSvcMock := new(MockSQSSvc)
+		sqsSvcMock.On("SendMessageBatch", mock.AnythingOfType("*sqs.SendMessageBatchInput")).Return(
+			&sqs.SendMessageBatchOutput{
+				Successful: []*sqs.SendMessageBatchResultEntry{{}, {}},
+			}, nil)
+
+		enqueuer := newEnqueuer(nil, new(MockS3Svc), sqsSvcMock)
+		for _, eventId := range []string{"1", "2", "1"} {
+			enqueuer.add(cloudtrail.CloudTrailEvent{
+				EventID:            eventId,
+				EventTime:          "2012-11-01T22:08:41Z",
+				RecipientAccountID: "123456789012",
+				RequestParameters:  cloudtrail.RequestParameters{RoleName: "roleName"},
+			})
+		}
+		successful, lost := enqueuer.wait()
+		assert.Equal(t, int32(2), successful, queueUrl)
+		assert.Empty(t, lost, queueUrl)
+		sqsSvcMock.AssertNumberOfCalls(t, "SendMessageBatch", 1)
+		sendMessageBatchInput := sqsSvcMock.Calls[0].Arguments.Get(0).(*sqs.SendMessageBatchInput)
+		assert.Len(t, sendMessageBatchInput.Entries, 2, queueUrl)
+		assert.Equal(t, "1", *sendMessageBatchInput.Entries[0].Id, queueUrl)
+		assert.Equal(t, "2", *sendMessageBatchInput.Entries[1].Id, queueUrl)
+	}
+}
+
+func TestTailerRetry(t *testing.T) {
+	ctx := new(context.Context)
+
+	os.Setenv("SEND_MAX_ATTEMPTS", "2")
+	defer os.Unsetenv("SEND_MAX_ATTEMPTS")
+	defer func(delay time.Duration) {
+		retryBaseDelay = delay
+	}(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	s3Evt := events.S3Event{
+		Records: []events.S3EventRecord{{
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{
+					Name: "test",
+				},
+				Object: events.S3Object{
+					Key: "test",
+				},
+			},
+		}},
+	}
+
+	var records []cloudtrail.CloudTrailEvent
+	for _, eventId := range []string{"1", "2", "3", "4"} {
+		records = append(records, cloudtrail.CloudTrailEvent{
+			EventID:     eventId,
+			EventSource: "iam.amazonaws.com",
+			EventName:   "CreatePolicy",
+			EventTime:   "2012-11-01T22:08:41Z",
+		})
+	}
+	cloudTrailEvtsJson, _ := json.Marshal(cloudtrail.CloudTrailEvents{Records: records})
+
+	s3SvcMock := new(MockS3Svc)
+	s3SvcMock.On("GetObject", mock.AnythingOfType("*s3.GetObjectInput")).Return(
+		&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewBuffer(cloudTrailEvtsJson)),
+		}, nil)
+
+	sqsSvcMock := new(MockSQSSvc)
+	sqsSvcMock.On("SendMessageBatch", mock.AnythingOfType("*sqs.SendMessageBatchInput")).Return(
+		&sqs.SendMessageBatchOutput{
+			Successful: []*sqs.SendMessageBatchResultEntry{{Id: aws.String("1")}},
+			Failed: []*sqs.BatchResultErrorEntry{
+				{Id: aws.String("2"), SenderFault: aws.Bool(true)},
+				{Id: aws.String("3"), SenderFault: aws.Bool(false)},
+				{Id: aws.String("4"), SenderFault: aws.Bool(false)},
+			},
+		}, nil).Once()
+	sqsSvcMock.On("SendMessageBatch", mock.AnythingOfType("*sqs.SendMessageBatchInput")).Return(
+		&sqs.SendMessageBatchOutput{
+			Successful: []*sqs.SendMessageBatchResultEntry{{Id: aws.String("3")}},
+			Failed: []*sqs.BatchResultErrorEntry{
+				{Id: aws.String("4"), SenderFault: aws.Bool(false)},
+			},
+		}, nil).Once()
+
+	response, err := Tailer(*ctx, s3Evt, s3SvcMock, sqsSvcMock, new(MockCloudTrailSvc))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), response.Successful)
+	assert.Equal(t, int32(2), response.Failed)
+	assert.Equal(t, []string{"2", "4"}, response.LostEventIDs)
+	sqsSvcMock.AssertNumberOfCalls(t, "SendMessageBatch", 2)
+	retryInput := sqsSvcMock.Calls[1].Arguments.Get(0).(*sqs.SendMessageBatchInput)
+	assert.Len(t, retryInput.Entries, 2)
+	assert.Equal(t, "3", *retryInput.Entries[0].Id)
+	assert.Equal(t, "4", *retryInput.Entries[1].Id)
+}
+
+func TestTailerLostEvent(t *testing.T) {
+	ctx := new(context.Context)
+
+	os.Setenv("SEND_MAX_ATTEMPTS", "3")
+	defer os.Unsetenv("SEND_MAX_ATTEMPTS")
+	defer func(delay time.Duration) {
+		retryBaseDelay = delay
+	}(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	s3Evt := events.S3Event{
+		Records: []events.S3EventRecord{{
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{
+					Name: "test",
+				},
+				Object: events.S3Object{
+					Key: "test",
+				},
+			},
+		}},
+	}
+
+	var records []cloudtrail.CloudTrailEvent
+	for _, eventId := range []string{"1", "2"} {
+		records = append(records, cloudtrail.CloudTrailEvent{
+			EventID:     eventId,
+			EventSource: "iam.amazonaws.com",
+			EventName:   "CreatePolicy",
+			EventTime:   "2012-11-01T22:08:41Z",
+		})
+	}
+	cloudTrailEvtsJson, _ := json.Marshal(cloudtrail.CloudTrailEvents{Records: records})
+
+	s3SvcMock := new(MockS3Svc)
+	s3SvcMock.On("GetObject", mock.AnythingOfType("*s3.GetObjectInput")).Return(
+		&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewBuffer(cloudTrailEvtsJson)),
+		}, nil)
+
+	// The second event fails on every attempt while the first is sent.
+	sqsSvcMock := new(MockSQSSvc)
+	sqsSvcMock.On("SendMessageBatch", mock.AnythingOfType("*sqs.SendMessageBatchInput")).Return(
+		&sqs.SendMessageBatchOutput{
+			Successful: []*sqs.SendMessageBatchResultEntry{{Id: aws.String("1")}},
+			Failed:     []*sqs.BatchResultErrorEntry{{Id: aws.String("2"), SenderFault: aws.Bool(false)}},
+		}, nil).Once()
+	sqsSvcMock.On("SendMessageBatch", mock.AnythingOfType("*sqs.SendMessageBatchInput")).Return(
+		&sqs.SendMessageBatchOutput{
+			Failed: []*sqs.BatchResultErrorEntry{{Id: aws.String("2"), SenderFault: aws.Bool(false)}},
+		}, nil)
+
+	response, err := Tailer(*ctx, s3Evt, s3SvcMock, sqsSvcMock, new(MockCloudTrailSvc))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), response.Successful)
+	assert.Equal(t, int32(1), response.Failed)
+	assert.Equal(t, []string{"2"}, response.LostEventIDs)
+	assert.Empty(t, response.FailedKeys)
+	sqsSvcMock.AssertNumberOfCalls(t, "SendMessageBatch", 3)
+}
+
+func TestTailerStream(t *testing.T) {
+	ctx := new(context.Context)
+
+	s3Evt := events.S3Event{
+		Records: []events.S3EventRecord{{
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{
+					Name: "test",
+				},
+				Object: events.S3Object{
+					Key: "test",
+				},
+			},
+		}},
+	}
+
+	var records []string
+	for i := 0; i < 25; i++ {
+		records = append(records, `{"eventID":"`+strconv.Itoa(i)+`","eventSource":"iam.amazonaws.com",`+
+			`"eventName":"CreatePolicy","eventTime":"2012-11-01T22:08:41Z"}`)
+	}
+	body := `{"Digest":{"Records":[{"eventName":"Ignored"}]},"Records":[` + strings.Join(records, ",") + `]}`
+
+	s3SvcMock := new(MockS3Svc)
+	s3SvcMock.On("GetObject", mock.AnythingOfType("*s3.GetObjectInput")).Return(
+		&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(strings.NewReader(body)),
+		}, nil)
+
+	sqsSvcMock := new(MockSQSSvc)
+	sqsSvcMock.On("SendMessageBatch", mock.MatchedBy(func(input *sqs.SendMessageBatchInput) bool {
+		return len(input.Entries) == 10
+	})).Return(&sqs.SendMessageBatchOutput{
+		Successful: make([]*sqs.SendMessageBatchResultEntry, 10),
+	}, nil)
+	sqsSvcMock.On("SendMessageBatch", mock.MatchedBy(func(input *sqs.SendMessageBatchInput) bool {
+		return len(input.Entries) == 5
+	})).Return(&sqs.SendMessageBatchOutput{
+		Successful: make([]*sqs.SendMessageBatchResultEntry, 5),
+	}, nil)
+
+	response, err := Tailer(*ctx, s3Evt, s3SvcMock, sqsSvcMock, new(MockCloudTrailSvc))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(25), response.Successful)
+	sqsSvcMock.AssertNumberOfCalls(t, "SendMessageBatch", 3)
+
+	// A truncated log file fails the object.
+	s3SvcMock = new(MockS3Svc)
+	s3SvcMock.On("GetObject", mock.AnythingOfType("*s3.GetObjectInput")).Return(
+		&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(strings.NewReader(body[:len(body)/2])),
+		}, nil)
+	sqsSvcMock = new(MockSQSSvc)
+	sqsSvcMock.On("SendMessageBatch", mock.AnythingOfType("*sqs.SendMessageBatchInput")).Return(
+		&sqs.SendMessageBatchOutput{}, nil)
+
+	response, err = Tailer(*ctx, s3Evt, s3SvcMock, sqsSvcMock, new(MockCloudTrailSvc))
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"test"}, response.FailedKeys)
+}
+
+func TestTailerOverflow(t *testing.T) {
+	ctx := new(context.Context)
+
+	os.Setenv("OVERFLOW_BUCKET", "overflow")
+	defer os.Unsetenv("OVERFLOW_BUCKET")
+
+	s3Evt := events.S3Event{
+		Records: []events.S3EventRecord{{
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{
+					Name: "test",
+				},
+				Object: events.S3Object{
+					Key: "test",
+				},
+			},
+		}},
+	}
+
+	// One event over the message limit followed by three that only fit two to a batch.
+	var records []cloudtrail.CloudTrailEvent
+	for i, policyDocumentBytes := range []int{300 * 1024, 100 * 1024, 100 * 1024, 100 * 1024} {
+		records = append(records, cloudtrail.CloudTrailEvent{
+			EventID:     strconv.Itoa(i),
+			EventSource: "iam.amazonaws.com",
+			EventName:   "CreatePolicy",
+			EventTime:   "2012-11-01T22:08:41Z",
+			RequestParameters: cloudtrail.RequestParameters{
+				PolicyName:     "policyName",
+				PolicyDocument: strings.Repeat("a", policyDocumentBytes),
+			},
+		})
+	}
+	cloudTrailEvtsJson, _ := json.Marshal(cloudtrail.CloudTrailEvents{Records: records})
+
+	s3SvcMock := new(MockS3Svc)
+	s3SvcMock.On("GetObject", mock.AnythingOfType("*s3.GetObjectInput")).Return(
+		&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewBuffer(cloudTrailEvtsJson)),
+		}, nil)
+	s3SvcMock.On("PutObject", mock.AnythingOfType("*s3.PutObjectInput")).Return(&s3.PutObjectOutput{}, nil)
+
+	sqsSvcMock := new(MockSQSSvc)
+	sqsSvcMock.On("SendMessageBatch", mock.MatchedBy(func(input *sqs.SendMessageBatchInput) bool {
+		return len(input.Entries) == 3
+	})).Return(&sqs.SendMessageBatchOutput{
+		Successful: make([]*sqs.SendMessageBatchResultEntry, 3),
+	}, nil)
+	sqsSvcMock.On("SendMessageBatch", mock.MatchedBy(func(input *sqs.SendMessageBatchInput) bool {
+		return len(input.Entries) == 1
+	})).Return(&sqs.SendMessageBatchOutput{
+		Successful: make([]*sqs.SendMessageBatchResultEntry, 1),
+	}, nil)
+
+	response, err := Tailer(*ctx, s3Evt, s3SvcMock, sqsSvcMock, new(MockCloudTrailSvc))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(4), response.Successful)
+	sqsSvcMock.AssertNumberOfCalls(t, "SendMessageBatch", 2)
+	s3SvcMock.AssertNumberOfCalls(t, "PutObject", 1)
+	putObjectInput := s3SvcMock.Calls[1].Arguments.Get(0).(*s3.PutObjectInput)
+	assert.Equal(t, "overflow", *putObjectInput.Bucket)
+	assert.Equal(t, "overflow/0.json", *putObjectInput.Key)
+	for _, call := range sqsSvcMock.Calls {
+		sendMessageBatchInput := call.Arguments.Get(0).(*sqs.SendMessageBatchInput)
+		batchBytes := 0
+		for _, entry := range sendMessageBatchInput.Entries {
+			batchBytes += len(*entry.MessageBody)
+			if *entry.Id == "0" {
+				assert.Equal(t, "overflow/0.json", *entry.MessageAttributes[queue.OverflowKeyAttribute].StringValue)
+				assert.NotContains(t, *entry.MessageBody, "policyDocument")
+			}
+		}
+		assert.True(t, batchBytes <= 256*1024)
+	}
+}
+
+func TestTailerValidation(t *testing.T) {
+	ctx := new(context.Context)
+
+	logKey := "AWSLogs/123456789012/CloudTrail/us-east-1/2012/11/01/" +
+		"123456789012_CloudTrail_us-east-1_20121101T2205Z_abc.json.gz"
+	digestKey := "AWSLogs/123456789012/CloudTrail-Digest/us-east-1/2012/11/01/" +
+		"123456789012_CloudTrail-Digest_us-east-1_trail_us-east-1_20121101T230000Z.json.gz"
+	s3Evt := events.S3Event{
+		Records: []events.S3EventRecord{{
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{
+					Name: "test",
+				},
+				Object: events.S3Object{
+					Key: logKey,
+				},
+			},
+		}, {
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{
+					Name: "test",
+				},
+				Object: events.S3Object{
+					Key: digestKey,
+				},
+			},
+		}},
+	}
+
+	logContent, _ := json.Marshal(cloudtrail.CloudTrailEvents{
+		Records: []cloudtrail.CloudTrailEvent{{
+			EventID:     "1",
+			EventSource: "iam.amazonaws.com",
+			EventName:   "CreatePolicy",
+			EventTime:   "2012-11-01T22:04:41Z",
+		}},
+	})
+	logHash := sha256.Sum256(logContent)
+
+	// Sign a digest file covering the log file the way CloudTrail does.
+	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	digestContent, _ := json.Marshal(cloudtrail.Digest{
+		DigestStartTime:            "2012-11-01T22:00:00Z",
+		DigestEndTime:              "2012-11-01T23:00:00Z",
+		DigestS3Bucket:             "test",
+		DigestS3Object:             digestKey,
+		DigestPublicKeyFingerprint: "fingerprint",
+		LogFiles: []cloudtrail.DigestLogFile{{
+			S3Bucket:  "test",
+			S3Object:  logKey,
+			HashValue: hex.EncodeToString(logHash[:]),
+		}},
+	})
+	digestHash := sha256.Sum256(digestContent)
+	dataSigningHash := sha256.Sum256([]byte("2012-11-01T23:00:00Z\ntest/" + digestKey + "\n" +
+		hex.EncodeToString(digestHash[:]) + "\nnull"))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, dataSigningHash[:])
+
+	newS3SvcMock := func(logContent []byte) *MockS3Svc {
+		s3SvcMock := new(MockS3Svc)
+		s3SvcMock.On("GetObject", &s3.GetObjectInput{
+			Bucket: aws.String("test"),
+			Key:    aws.String(digestKey),
+		}).Return(&s3.GetObjectOutput{
+			Body:     ioutil.NopCloser(bytes.NewBuffer(digestContent)),
+			Metadata: map[string]*string{"Signature": aws.String(hex.EncodeToString(signature))},
+		}, nil)
+		s3SvcMock.On("GetObject", &s3.GetObjectInput{
+			Bucket: aws.String("test"),
+			Key:    aws.String(logKey),
+		}).Return(&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewBuffer(logContent)),
+			ETag: aws.String("\"etag\""),
+		}, nil).Once()
+		s3SvcMock.On("GetObject", &s3.GetObjectInput{
+			Bucket:  aws.String("test"),
+			IfMatch: aws.String("\"etag\""),
+			Key:     aws.String(logKey),
+		}).Return(&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewBuffer(logContent)),
+			ETag: aws.String("\"etag\""),
+		}, nil).Once()
+		s3SvcMock.On("ListObjectsV2", mock.AnythingOfType("*s3.ListObjectsV2Input")).Return(
+			&s3.ListObjectsV2Output{KeyCount: aws.Int64(1)}, nil)
+
+		return s3SvcMock
+	}
+
+	cloudTrailSvcMock := new(MockCloudTrailSvc)
+	cloudTrailSvcMock.On("ListPublicKeys", mock.AnythingOfType("*cloudtrail.ListPublicKeysInput")).Return(
+		&awscloudtrail.ListPublicKeysOutput{
+			PublicKeyList: []*awscloudtrail.PublicKey{{
+				Fingerprint: aws.String("fingerprint"),
+				Value:       x509.MarshalPKCS1PublicKey(&privateKey.PublicKey),
+			}},
+		}, nil)
+
+	sqsSvcMock := new(MockSQSSvc)
+	sqsSvcMock.On("SendMessageBatch", mock.AnythingOfType("*sqs.SendMessageBatchInput")).Return(
+		&sqs.SendMessageBatchOutput{
+			Successful: []*sqs.SendMessageBatchResultEntry{{}},
+		}, nil)
+
+	// An untampered log file is tailed once its digest file is delivered, verified and flagged as such, reading it once
+	// to hash it and once more for its events pinned to the object hashed.
+	os.Setenv("LOG_FILE_VALIDATION", "flag")
+	defer os.Unsetenv("LOG_FILE_VALIDATION")
+	s3SvcMock := newS3SvcMock(logContent)
+	response, err := Tailer(*ctx, s3Evt, s3SvcMock, sqsSvcMock, cloudTrailSvcMock)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), response.Successful)
+	assert.Len(t, response.Objects, 1)
+	assert.Equal(t, logKey, response.Objects[0].Key)
+	assert.Equal(t, queue.LogFileVerified, response.Objects[0].Validation)
+	s3SvcMock.AssertNumberOfCalls(t, "GetObject", 3)
+	s3SvcMock.AssertCalled(t, "GetObject", &s3.GetObjectInput{
+		Bucket:  aws.String("test"),
+		IfMatch: aws.String("\"etag\""),
+		Key:     aws.String(logKey),
+	})
+	sendMessageBatchInput := sqsSvcMock.Calls[0].Arguments.Get(0).(*sqs.SendMessageBatchInput)
+	assert.Equal(t, queue.LogFileVerified,
+		*sendMessageBatchInput.Entries[0].MessageAttributes[queue.LogFileValidationAttribute].StringValue)
+
+	// A tampered log file is rejected.
+	os.Setenv("LOG_FILE_VALIDATION", "reject")
+	tamperedContent := bytes.Replace(logContent, []byte("CreatePolicy"), []byte("DeletePolicy"), 1)
+	response, err = Tailer(*ctx, s3Evt, newS3SvcMock(tamperedContent), sqsSvcMock, cloudTrailSvcMock)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(0), response.Successful)
+	assert.Equal(t, []string{logKey}, response.RejectedKeys)
+	assert.Equal(t, queue.LogFileInvalid, response.Objects[0].Validation)
+	sqsSvcMock.AssertNumberOfCalls(t, "SendMessageBatch", 1)
+
+	// A digest file signed with a key CloudTrail does not list leaves its log files unverifiable, rejecting them.
+	unknownKeyCloudTrailSvcMock := new(MockCloudTrailSvc)
+	unknownKeyCloudTrailSvcMock.On("ListPublicKeys", mock.AnythingOfType("*cloudtrail.ListPublicKeysInput")).Return(
+		&awscloudtrail.ListPublicKeysOutput{}, nil)
+	response, err = Tailer(*ctx, s3Evt, newS3SvcMock(logContent), sqsSvcMock, unknownKeyCloudTrailSvcMock)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{logKey}, response.RejectedKeys)
+	assert.Equal(t, queue.LogFileUnverifiable, response.Objects[0].Validation)
+
+	// Failing to list the public keys fails the digest file for it to be retried rather than rejecting its log files.
+	failingCloudTrailSvcMock := new(MockCloudTrailSvc)
+	failingCloudTrailSvcMock.On("ListPublicKeys", mock.AnythingOfType("*cloudtrail.ListPublicKeysInput")).Return(
+		(*awscloudtrail.ListPublicKeysOutput)(nil), errors.New("ThrottlingException"))
+	response, err = Tailer(*ctx, s3Evt, newS3SvcMock(logContent), sqsSvcMock, failingCloudTrailSvcMock)
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{digestKey}, response.FailedKeys)
+	assert.Empty(t, response.RejectedKeys)
+	assert.Empty(t, response.Objects)
+	sqsSvcMock.AssertNumberOfCalls(t, "SendMessageBatch", 1)
+
+	// A log file of a trail that never delivered a digest file is skipped after checking for one.
+	s3SvcMock = new(MockS3Svc)
+	s3SvcMock.On("ListObjectsV2", mock.AnythingOfType("*s3.ListObjectsV2Input")).Return(
+		&s3.ListObjectsV2Output{KeyCount: aws.Int64(0)}, nil)
+	response, err = Tailer(*ctx, events.S3Event{Records: s3Evt.Records[:1]}, s3SvcMock, sqsSvcMock,
+		cloudTrailSvcMock)
+	assert.Nil(t, err)
+	assert.Empty(t, response.Objects)
+	s3SvcMock.AssertNotCalled(t, "GetObject", mock.Anything)
+	listObjectsInput := s3SvcMock.Calls[0].Arguments.Get(0).(*s3.ListObjectsV2Input)
+	assert.Equal(t, "AWSLogs/123456789012/CloudTrail-Digest/us-east-1/", *listObjectsInput.Prefix)
+
+	// An unknown validation fails rather than tailing anything.
+	os.Setenv("LOG_FILE_VALIDATION", "true")
+	_, err = Tailer(*ctx, s3Evt, new(MockS3Svc), sqsSvcMock, cloudTrailSvcMock)
+	assert.NotNil(t, err)
+
+	// The digest file is skipped without validation, leaving the log file to be tailed as delivered.
+	os.Unsetenv("LOG_FILE_VALIDATION")
+	s3SvcMock = newS3SvcMock(logContent)
+	response, err = Tailer(*ctx, s3Evt, s3SvcMock, sqsSvcMock, cloudTrailSvcMock)
+	assert.Nil(t, err)
+	assert.Len(t, response.Objects, 1)
+	assert.Empty(t, response.Objects[0].Validation)
+	s3SvcMock.AssertNumberOfCalls(t, "GetObject", 1)
+}
+
+func TestTailerKeyAttributes(t *testing.T) {
+	ctx := new(context.Context)
+
+	s3Evt := events.S3Event{
+		Records: []events.S3EventRecord{{
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{
+					Name: "test",
+				},
+				Object: events.S3Object{
+					Key: "AWSLogs/123456789012/CloudTrail/us-east-1/2012/11/01/test.json.gz",
+				},
+			},
+		}, {
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{
+					Name: "test",
+				},
+				Object: events.S3Object{
+					Key: "prefix/AWSLogs/o-abc123/210987654321/CloudTrail/eu-west-1/2012/11/01/test.json.gz",
+				},
+			},
+		}},
+	}
+
+	cloudTrailEvtsJson, _ := json.Marshal(cloudtrail.CloudTrailEvents{
+		Records: []cloudtrail.CloudTrailEvent{{
+			EventSource: "iam.amazonaws.com",
+			EventName:   "CreatePolicy",
+			EventTime:   "2012-11-01T22:08:41Z",
+		}},
+	})
+
+	s3SvcMock := new(MockS3Svc)
+	s3SvcMock.On("GetObject", mock.AnythingOfType("*s3.GetObjectInput")).Return(
+		&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewBuffer(cloudTrailEvtsJson)),
+		}, nil).Once()
+	s3SvcMock.On("GetObject", mock.AnythingOfType("*s3.GetObjectInput")).Return(
+		&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewBuffer(cloudTrailEvtsJson)),
+		}, nil).Once()
+
+	sqsSvcMock := new(MockSQSSvc)
+	sqsSvcMock.On("SendMessageBatch", mock.AnythingOfType("*sqs.SendMessageBatchInput")).Return(
+		&sqs.SendMessageBatchOutput{
+			Successful: []*sqs.SendMessageBatchResultEntry{{}},
+		}, nil)
+
+	response, err := Tailer(*ctx, s3Evt, s3SvcMock, sqsSvcMock, new(MockCloudTrailSvc))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), response.Successful)
+	for i, expected := range [][]string{
+		{"123456789012", "us-east-1", "s3://test/" + s3Evt.Records[0].S3.Object.Key},
+		{"210987654321", "eu-west-1", "s3://test/" + s3Evt.Records[1].S3.Object.Key},
+	} {
+		attributes := sqsSvcMock.Calls[i].Arguments.Get(0).(*sqs.SendMessageBatchInput).Entries[0].MessageAttributes
+		assert.Equal(t, expected[0], *attributes[queue.AccountIDAttribute].StringValue)
+		assert.Equal(t, expected[1], *attributes[queue.RegionAttribute].StringValue)
+		assert.Equal(t, expected[2], *attributes[queue.LogObjectAttribute].StringValue)
+	}
+}
+
+func TestEventBridgeTailer(t *testing.T) {
+	ctx := new(context.Context)
+
+	detail, _ := json.Marshal(cloudtrail.CloudTrailEvent{
+		EventID:     "1",
+		EventSource: "iam.amazonaws.com",
+		EventName:   "CreatePolicy",
+		EventTime:   "2012-11-01T22:08:41Z",
+	})
+	evt := events.CloudWatchEvent{
+		DetailType: "AWS API Call via CloudTrail",
+		Source:     "aws.iam",
+		AccountID:  "123456789012",
+		Region:     "us-east-1",
+		Detail:     detail,
+	}
+
+	sqsSvcMock := new(MockSQSSvc)
+	sqsSvcMock.On("SendMessageBatch", mock.AnythingOfType("*sqs.SendMessageBatchInput")).Return(
+		&sqs.SendMessageBatchOutput{
+			Successful: []*sqs.SendMessageBatchResultEntry{{}},
+		}, nil)
+
+	response, err := EventBridgeTailer(*ctx, evt, new(MockS3Svc), sqsSvcMock)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), response.Successful)
+	sendMessageBatchInput := sqsSvcMock.Calls[0].Arguments.Get(0).(*sqs.SendMessageBatchInput)
+	assert.Len(t, sendMessageBatchInput.Entries, 1)
+	assert.Equal(t, "1", *sendMessageBatchInput.Entries[0].Id)
+	assert.Equal(t, "123456789012",
+		*sendMessageBatchInput.Entries[0].MessageAttributes[queue.AccountIDAttribute].StringValue)
+
+	// Events that are not CloudTrail API calls or that the filter drops are not sent.
+	evt.DetailType = "Scheduled Event"
+	response, err = EventBridgeTailer(*ctx, evt, new(MockS3Svc), sqsSvcMock)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), response.Filtered)
+	evt.DetailType = "AWS API Call via CloudTrail"
+	evt.Detail, _ = json.Marshal(cloudtrail.CloudTrailEvent{
+		EventSource: "iam.amazonaws.com",
+		EventName:   "GetRole",
+		ReadOnly:    true,
+	})
+	response, err = EventBridgeTailer(*ctx, evt, new(MockS3Svc), sqsSvcMock)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), response.Filtered)
+	sqsSvcMock.AssertNumberOfCalls(t, "SendMessageBatch", 1)
+}
+
+func TestSendMaxAttempts(t *testing.T) {
+	defer os.Unsetenv("SEND_MAX_ATTEMPTS")
+	for value, expected := range map[string]int{"": defaultSendMaxAttempts, "3": 3, "three": defaultSendMaxAttempts,
+		"0": defaultSendMaxAttempts} {
+		os.Setenv("SEND_MAX_ATTEMPTS", value)
+		assert.Equal(t, expected, sendMaxAttempts())
+	}
+}
+
+func TestEntityName(t *testing.T) {
+	for expected, requestParameters := range map[string]cloudtrail.RequestParameters{
+		"account":                    {},
+		"group/admins":               {GroupName: "admins", UserName: "userName"},
+		"instance-profile/webServer": {InstanceProfileName: "webServer", RoleName: "roleName"},
+		"policy/policyName":          {PolicyArn: "arn:aws:iam::123456789012:policy/policyName"},
+		"key/1234abcd-12ab-34cd-56ef-1234567890ab": {
+			KeyID:      "arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab",
+			PolicyName: "default",
+		},
+		"saml-provider/okta":                        {Name: "okta", SAMLMetadataDocument: "<EntityDescriptor/>"},
+		"organizations-policy/p-examplepolicyid111": {PolicyId: "p-examplepolicyid111", TargetId: "ou-examplerootid111"},
+		"oidc-provider/token.actions.githubusercontent.com": {
+			OpenIDConnectProviderArn: "arn:aws:iam::123456789012:oidc-provider/token.actions.githubusercontent.com",
+		},
+	} {
+		assert.Equal(t, expected, entityName(&cloudtrail.CloudTrailEvent{RequestParameters: requestParameters}))
+	}
+	assert.Equal(t, "oidc-provider/token.actions.githubusercontent.com", entityName(&cloudtrail.CloudTrailEvent{
+		RequestParameters: cloudtrail.RequestParameters{URL: "https://token.actions.githubusercontent.com"},
+	}))
+
+	// A message group of an entity with a long name stays within what SQS accepts and apart from similar names.
+	longName := strings.Repeat("p", 128)
+	groupId := messageGroupId(&cloudtrail.CloudTrailEvent{
+		RecipientAccountID: "123456789012",
+		RequestParameters:  cloudtrail.RequestParameters{PolicyName: longName},
+	})
+	assert.Len(t, groupId, maxMessageGroupIdLength)
+	assert.True(t, strings.HasPrefix(groupId, "123456789012/policy/ppp"))
+	assert.NotEqual(t, groupId, messageGroupId(&cloudtrail.CloudTrailEvent{
+		RecipientAccountID: "123456789012",
+		RequestParameters:  cloudtrail.RequestParameters{PolicyName: longName + "q"},
+	}))
+	assert.Equal(t, "123456789012/role/roleName", messageGroupId(&cloudtrail.CloudTrailEvent{
+		RecipientAccountID: "123456789012",
+		RequestParameters:  cloudtrail.RequestParameters{RoleName: "roleName"},
+	}))
+}
+
+func TestTailerMismatchedValues(t *testing.T) {
+	ctx := new(context.Context)
+
+	s3Evt := events.S3Event{
+		Records: []events.S3EventRecord{{
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{
+					Name: "test",
+				},
+				Object: events.S3Object{
+					Key: "test",
+				},
+			},
+		}},
+	}
+
+	// S3 records the policy of PutBucketPolicy as an array and SQS records the tags of CreateQueue as an object.
+	logContent := `{"Records": [{
+		"eventSource": "s3.amazonaws.com",
+		"eventName": "PutBucketPolicy",
+		"eventTime": "2012-11-01T22:08:41Z",
+		"requestParameters": {"bucketName": "bucketName", "bucketPolicy": {"Version": "2012-10-17"}, "policy": [""]}
+	}, {
+		"eventSource": "sqs.amazonaws.com",
+		"eventName": "CreateQueue",
+		"eventTime": "2012-11-01T22:09:41Z",
+		"requestParameters": {"queueName": "queueName", "tags": {"team": "platform"}}
+	}]}`
+
+	s3SvcMock := new(MockS3Svc)
+	s3SvcMock.On("GetObject", mock.AnythingOfType("*s3.GetObjectInput")).Return(
+		&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewBufferString(logContent)),
+		}, nil)
+
+	sqsSvcMock := new(MockSQSSvc)
+	sqsSvcMock.On("SendMessageBatch", mock.AnythingOfType("*sqs.SendMessageBatchInput")).Return(
+		&sqs.SendMessageBatchOutput{
+			Successful: []*sqs.SendMessageBatchResultEntry{{}, {}},
+		}, nil)
+
+	response, err := Tailer(*ctx, s3Evt, s3SvcMock, sqsSvcMock, new(MockCloudTrailSvc))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), response.Successful)
+	sendMessageBatchInput := sqsSvcMock.Calls[0].Arguments.Get(0).(*sqs.SendMessageBatchInput)
+	assert.Contains(t, *sendMessageBatchInput.Entries[0].MessageBody, `"bucketPolicy":{"Version":"2012-10-17"}`)
 }
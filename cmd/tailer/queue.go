@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/dlabey/iam-git-auditor/pkg/cloudtrail"
+	"github.com/dlabey/iam-git-auditor/pkg/queue"
+	"github.com/dlabey/iam-git-auditor/pkg/utils"
+	"log"
+	"math/rand"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The most entries SQS accepts in a single SendMessageBatch.
+const partitionSize = 10
+
+// The most characters SQS accepts in a MessageGroupId.
+const maxMessageGroupIdLength = 128
+
+// The most bytes SQS accepts in a single message as well as in a single SendMessageBatch.
+const maxMessageBytes = 256 * 1024
+
+// Where CloudTrail events too large for a message are kept in the overflow bucket.
+const overflowPrefix = "overflow/"
+
+// The most batches being sent to SQS at once, which bounds how many CloudTrail events are held in memory.
+const maxConcurrentBatches = 10
+
+// The most records a FIFO queue holds back across its message groups before sending them all. Records are only sorted
+// by event time within what is held back for their message group, which is at most a partition or this many records
+// in all, so a record CloudTrail logs after newer ones of its group that were already sent is still sent after them.
+const maxPendingMessages = maxConcurrentBatches * partitionSize
+
+// How many times a batch is sent when SEND_MAX_ATTEMPTS is unset or invalid.
+const defaultSendMaxAttempts = 5
+
+// The bounds of the backoff between sending the failed entries of a batch again.
+var retryBaseDelay = 100 * time.Millisecond
+var retryMaxDelay = 5 * time.Second
+
+// Whether the queue is FIFO, which SQS requires to be reflected in the queue name.
+func isFifo(queueUrl string) bool {
+	return strings.HasSuffix(queueUrl, ".fifo")
+}
+
+// Returns the IAM entity the CloudTrail event changes, so that events for the same entity are delivered in order.
+func entityName(cloudTrailEvt *cloudtrail.CloudTrailEvent) string {
+	requestParameters := cloudTrailEvt.RequestParameters
+	switch {
+	// Group membership and instance profile events name a user or role too, but change the group or instance profile.
+	case requestParameters.GroupName != "":
+		return "group/" + requestParameters.GroupName
+	case requestParameters.InstanceProfileName != "":
+		return "instance-profile/" + requestParameters.InstanceProfileName
+	case requestParameters.RoleName != "":
+		return "role/" + requestParameters.RoleName
+	case requestParameters.UserName != "":
+		return "user/" + requestParameters.UserName
+	// Resource policies are named too, as every KMS key policy is named default, but change the resource.
+	case requestParameters.BucketName != "":
+		return "bucket/" + requestParameters.BucketName
+	case requestParameters.KeyID != "":
+		return "key/" + path.Base(requestParameters.KeyID)
+	case requestParameters.QueueURL != "":
+		return "queue/" + path.Base(requestParameters.QueueURL)
+	case requestParameters.TopicArn != "":
+		return "topic/" + requestParameters.TopicArn[strings.LastIndex(requestParameters.TopicArn, ":")+1:]
+	case requestParameters.FunctionName != "":
+		return "function/" + cloudtrail.FunctionName(requestParameters.FunctionName)
+	case requestParameters.PolicyName != "":
+		return "policy/" + requestParameters.PolicyName
+	case requestParameters.PolicyArn != "":
+		return "policy/" + path.Base(requestParameters.PolicyArn)
+	case requestParameters.SAMLProviderArn != "":
+		return "saml-provider/" + cloudtrail.IdentityProviderName(requestParameters.SAMLProviderArn)
+	case requestParameters.SAMLMetadataDocument != "":
+		return "saml-provider/" + requestParameters.Name
+	case requestParameters.OpenIDConnectProviderArn != "":
+		return "oidc-provider/" + cloudtrail.IdentityProviderName(requestParameters.OpenIDConnectProviderArn)
+	case requestParameters.URL != "":
+		return "oidc-provider/" + cloudtrail.OpenIDConnectProviderName(requestParameters.URL)
+	case requestParameters.PermissionSetArn != "":
+		return "permission-set/" + path.Base(requestParameters.PermissionSetArn)
+	case cloudTrailEvt.ResponseElements.PermissionSet.PermissionSetArn != "":
+		return "permission-set/" + path.Base(cloudTrailEvt.ResponseElements.PermissionSet.PermissionSetArn)
+	case requestParameters.PolicyId != "":
+		return "organizations-policy/" + requestParameters.PolicyId
+	case cloudTrailEvt.ResponseElements.Policy.PolicySummary.ID != "":
+		return "organizations-policy/" + cloudTrailEvt.ResponseElements.Policy.PolicySummary.ID
+	}
+
+	return "account"
+}
+
+// Returns the FIFO message group of the CloudTrail event made of its account and entity. One too long for SQS, as IAM
+// names and OIDC provider URLs can be, keeps its start followed by a hash of the whole to stay distinct.
+func messageGroupId(cloudTrailEvt *cloudtrail.CloudTrailEvent) string {
+	groupId := cloudTrailEvt.RecipientAccountID + "/" + entityName(cloudTrailEvt)
+	if len(groupId) <= maxMessageGroupIdLength {
+		return groupId
+	}
+	hash := sha256.Sum256([]byte(groupId))
+	hashHex := hex.EncodeToString(hash[:])
+
+	return groupId[:maxMessageGroupIdLength-len(hashHex)-1] + "/" + hashHex
+}
+
+// An SQS message prepared from a CloudTrail event.
+type message struct {
+	eventId    string
+	eventTime  string
+	groupId    string
+	body       string
+	attributes map[string]*sqs.MessageAttributeValue
+}
+
+// Returns the size SQS counts against its limits, which includes the message attributes.
+func (m *message) size() int {
+	size := len(m.body)
+	for name, attribute := range m.attributes {
+		size += len(name) + len(aws.StringValue(attribute.DataType)) + len(aws.StringValue(attribute.StringValue))
+	}
+
+	return size
+}
+
+// Whether the message can be added to the partition without going over either SQS batch limit.
+func fits(partition []*message, partitionBytes int, m *message) bool {
+	return len(partition) < partitionSize && partitionBytes+m.size() <= maxMessageBytes
+}
+
+// Partitions the messages into partitions of up to 10 messages and up to the SQS batch size.
+func partition(messages []*message) [][]*message {
+	var partitions [][]*message
+	var current []*message
+	var currentBytes int
+	for _, m := range messages {
+		if !fits(current, currentBytes, m) {
+			partitions = append(partitions, current)
+			current, currentBytes = nil, 0
+		}
+		current = append(current, m)
+		currentBytes += m.size()
+	}
+	if len(current) > 0 {
+		partitions = append(partitions, current)
+	}
+
+	return partitions
+}
+
+// Sorts the messages by event time, which CloudTrail event times being all UTC in the same layout sort lexically by.
+func sortByEventTime(messages []*message) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].eventTime < messages[j].eventTime
+	})
+}
+
+// Groups the records into partitions per FIFO message group, sorted by event time so that each group's partitions
+// can be sent in order.
+func group(messages []*message) [][][]*message {
+	var groups [][][]*message
+
+	sortByEventTime(messages)
+	var groupIds []string
+	groupMessages := make(map[string][]*message)
+	for _, m := range messages {
+		if _, ok := groupMessages[m.groupId]; !ok {
+			groupIds = append(groupIds, m.groupId)
+		}
+		groupMessages[m.groupId] = append(groupMessages[m.groupId], m)
+	}
+	for _, groupId := range groupIds {
+		groups = append(groups, partition(groupMessages[groupId]))
+	}
+
+	return groups
+}
+
+// Sends a partition as a single batch to the SQS queue, returning how many messages succeeded and the EventIDs of
+// those that were lost.
+func sendBatch(queueUrl string, fifo bool, maxAttempts int, partition []*message,
+	sqsSvc sqsiface.SQSAPI) (int32, []string) {
+	// Initialize an array of SendMessageBatchRequestEntry.
+	var entries []*sqs.SendMessageBatchRequestEntry
+	eventIds := make(map[string]bool)
+
+	// Go over the partition and prepare it for the request.
+	for i := 0; i < len(partition); i++ {
+		entry := &sqs.SendMessageBatchRequestEntry{
+			Id:                aws.String(partition[i].eventId),
+			MessageAttributes: partition[i].attributes,
+			MessageBody:       aws.String(partition[i].body),
+		}
+
+		// SQS rejects the whole batch when entries share an Id, so an event CloudTrail delivered twice is only sent once.
+		if eventIds[partition[i].eventId] {
+			log.Printf("msg=\"Skipped duplicate CloudTrail event\" eventId=\"%s\"", partition[i].eventId)
+			continue
+		}
+		eventIds[partition[i].eventId] = true
+
+		// FIFO queues do not support a per message delay and deduplicate on the event instead.
+		if fifo {
+			entry.MessageDeduplicationId = aws.String(partition[i].eventId)
+			entry.MessageGroupId = aws.String(partition[i].groupId)
+		} else {
+			entry.DelaySeconds = aws.Int64(10)
+		}
+		entries = append(entries, entry)
+	}
+
+	// Send the messages to the SQS queue, resending only the entries that failed on the receiver side.
+	var successful int32
+	var lost []string
+	for attempt := 1; len(entries) > 0; attempt++ {
+		sendMessageBatchOutput, err := sqsSvc.SendMessageBatch(&sqs.SendMessageBatchInput{
+			Entries:  entries,
+			QueueUrl: aws.String(queueUrl),
+		})
+
+		// Evaluate the response, where a failed request means every entry is retried.
+		var retries []*sqs.SendMessageBatchRequestEntry
+		if err != nil {
+			log.Printf("msg=\"Error sending SQS message\" attempt=%d err=\"%s\"", attempt, err)
+			retries = entries
+		} else {
+			log.Printf("msg=\"Sent message batch to SQS\" attempt=%d entriesLen=%d failedLen=%d", attempt,
+				len(entries), len(sendMessageBatchOutput.Failed))
+			successful += int32(len(sendMessageBatchOutput.Successful))
+			for _, failed := range sendMessageBatchOutput.Failed {
+				// A sender fault will fail the same way again, so the entry is lost right away.
+				if aws.BoolValue(failed.SenderFault) {
+					log.Printf("msg=\"SQS rejected message\" eventId=\"%s\" code=\"%s\" message=\"%s\"",
+						aws.StringValue(failed.Id), aws.StringValue(failed.Code), aws.StringValue(failed.Message))
+					lost = append(lost, aws.StringValue(failed.Id))
+					continue
+				}
+				for _, entry := range entries {
+					if aws.StringValue(entry.Id) == aws.StringValue(failed.Id) {
+						retries = append(retries, entry)
+					}
+				}
+			}
+		}
+
+		// Give up on the remaining entries once out of attempts.
+		if len(retries) > 0 && attempt >= maxAttempts {
+			for _, entry := range retries {
+				lost = append(lost, aws.StringValue(entry.Id))
+			}
+			break
+		}
+		if len(retries) > 0 {
+			time.Sleep(backoff(attempt))
+		}
+		entries = retries
+	}
+
+	return successful, lost
+}
+
+// Returns how many times a batch is sent before its failed entries are given up on. An invalid SEND_MAX_ATTEMPTS falls
+// back to the default rather than failing every batch being sent.
+func sendMaxAttempts() int {
+	maxAttempts := os.Getenv("SEND_MAX_ATTEMPTS")
+	if maxAttempts == "" {
+		return defaultSendMaxAttempts
+	}
+	attempts, err := strconv.Atoi(maxAttempts)
+	if err != nil || attempts < 1 {
+		log.Printf("msg=\"Invalid SEND_MAX_ATTEMPTS, using the default\" sendMaxAttempts=\"%s\" default=%d", maxAttempts,
+			defaultSendMaxAttempts)
+
+		return defaultSendMaxAttempts
+	}
+
+	return attempts
+}
+
+// Returns a random delay of up to the exponential backoff for the attempt so that retries do not align.
+func backoff(attempt int) time.Duration {
+	ceiling := retryBaseDelay << uint(attempt)
+	if ceiling > retryMaxDelay || ceiling <= 0 {
+		ceiling = retryMaxDelay
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// Streams CloudTrail events to the SQS queue, sending each partition as soon as it fills up with a bounded number of
+// batches in flight. A FIFO queue holds back a partition per message group to sort it by event time, sending a group's
+// partition once it fills up and every group's once too many records are held back, one after another per group.
+type enqueuer struct {
+	attributes     map[string]*sqs.MessageAttributeValue
+	queueUrl       string
+	fifo           bool
+	maxAttempts    int
+	overflowBucket string
+	s3Svc          s3iface.S3API
+	sqsSvc         sqsiface.SQSAPI
+	pending        []*message
+	pendingBytes   int
+	groupPending   map[string][]*message
+	groupBytes     map[string]int
+	groupLen       int
+	groupDone      map[string]chan struct{}
+	semaphore      chan struct{}
+	waitGroup      sync.WaitGroup
+	successful     int32
+	lost           []string
+	lostMutex      sync.Mutex
+	groups         int
+}
+
+func newEnqueuer(attributes map[string]*sqs.MessageAttributeValue, s3Svc s3iface.S3API,
+	sqsSvc sqsiface.SQSAPI) *enqueuer {
+	queueUrl := os.Getenv("QUEUE_URL")
+
+	return &enqueuer{
+		attributes:     attributes,
+		queueUrl:       queueUrl,
+		fifo:           isFifo(queueUrl),
+		maxAttempts:    sendMaxAttempts(),
+		overflowBucket: os.Getenv("OVERFLOW_BUCKET"),
+		s3Svc:          s3Svc,
+		sqsSvc:         sqsSvc,
+		groupPending:   make(map[string][]*message),
+		groupBytes:     make(map[string]int),
+		groupDone:      make(map[string]chan struct{}),
+		semaphore:      make(chan struct{}, maxConcurrentBatches),
+	}
+}
+
+// Prepares the message of a CloudTrail event. An event too large for SQS is stored in the overflow bucket and its
+// message only carries a pointer to it for the Auditor to resolve.
+func (e *enqueuer) newMessage(record *cloudtrail.CloudTrailEvent) (*message, error) {
+	messageBody, err := json.Marshal(record)
+	utils.CheckError(err, "msg=\"Error marshalling CloudTrailEvent\" err=\"%s\"")
+	m := &message{
+		eventId:    record.EventID,
+		eventTime:  record.EventTime,
+		groupId:    messageGroupId(record),
+		body:       string(messageBody),
+		attributes: make(map[string]*sqs.MessageAttributeValue),
+	}
+	for name, attribute := range e.attributes {
+		m.attributes[name] = attribute
+	}
+	if m.size() <= maxMessageBytes {
+		return m, nil
+	}
+	if e.overflowBucket == "" {
+		return nil, fmt.Errorf("CloudTrail event of %d bytes is over the SQS limit without an OVERFLOW_BUCKET",
+			len(messageBody))
+	}
+
+	// Store the whole CloudTrail event in the overflow bucket.
+	overflowKey := overflowPrefix + record.EventID + ".json"
+	_, err = e.s3Svc.PutObject(&s3.PutObjectInput{
+		Body:   bytes.NewReader(messageBody),
+		Bucket: aws.String(e.overflowBucket),
+		Key:    aws.String(overflowKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("msg=\"Stored oversized CloudTrail event\" eventId=\"%s\" bytes=%d overflowKey=\"%s\"", record.EventID,
+		len(messageBody), overflowKey)
+
+	// Keep enough of the CloudTrail event in the message to identify it.
+	pointerBody, err := json.Marshal(cloudtrail.CloudTrailEvent{
+		EventID:            record.EventID,
+		EventName:          record.EventName,
+		EventSource:        record.EventSource,
+		EventTime:          record.EventTime,
+		RecipientAccountID: record.RecipientAccountID,
+	})
+	utils.CheckError(err, "msg=\"Error marshalling CloudTrailEvent\" err=\"%s\"")
+	m.body = string(pointerBody)
+	m.attributes[queue.OverflowBucketAttribute] = &sqs.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(e.overflowBucket),
+	}
+	m.attributes[queue.OverflowKeyAttribute] = &sqs.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(overflowKey),
+	}
+
+	return m, nil
+}
+
+// Adds a CloudTrail event, sending the pending partition once the event no longer fits in it.
+func (e *enqueuer) add(record cloudtrail.CloudTrailEvent) {
+	m, err := e.newMessage(&record)
+	if err != nil {
+		log.Printf("msg=\"Error preparing SQS message\" eventId=\"%s\" err=\"%s\"", record.EventID, err)
+		e.lostMutex.Lock()
+		e.lost = append(e.lost, record.EventID)
+		e.lostMutex.Unlock()
+
+		return
+	}
+	if e.fifo {
+		e.addToGroup(m)
+
+		return
+	}
+	if !fits(e.pending, e.pendingBytes, m) {
+		e.send([][]*message{e.pending}, nil)
+		e.pending, e.pendingBytes = nil, 0
+	}
+	e.pending = append(e.pending, m)
+	e.pendingBytes += m.size()
+}
+
+// Adds a message to the partition held back for its FIFO message group.
+func (e *enqueuer) addToGroup(m *message) {
+	if !fits(e.groupPending[m.groupId], e.groupBytes[m.groupId], m) {
+		partition := e.groupPending[m.groupId]
+		sortByEventTime(partition)
+		e.sendGroup(m.groupId, [][]*message{partition})
+		e.groupLen -= len(partition)
+		delete(e.groupPending, m.groupId)
+		delete(e.groupBytes, m.groupId)
+	}
+	e.groupPending[m.groupId] = append(e.groupPending[m.groupId], m)
+	e.groupBytes[m.groupId] += m.size()
+	e.groupLen++
+	if e.groupLen >= maxPendingMessages {
+		e.flushGroups()
+	}
+}
+
+// Sends the partitions held back for every FIFO message group.
+func (e *enqueuer) flushGroups() {
+	var messages []*message
+	for _, partition := range e.groupPending {
+		messages = append(messages, partition...)
+	}
+	for _, partitions := range group(messages) {
+		e.sendGroup(partitions[0][0].groupId, partitions)
+	}
+	e.groupLen = 0
+	e.groupPending = make(map[string][]*message)
+	e.groupBytes = make(map[string]int)
+}
+
+// Sends the partitions of a FIFO message group once whatever was sent before for the group is done.
+func (e *enqueuer) sendGroup(groupId string, partitions [][]*message) {
+	e.groupDone[groupId] = e.send(partitions, e.groupDone[groupId])
+}
+
+// Concurrently sends the partitions of a group in order to the SQS queue after the previous send is done, returning a
+// channel closed once they are sent.
+func (e *enqueuer) send(partitions [][]*message, previous <-chan struct{}) chan struct{} {
+	groupIdx := e.groups
+	e.groups++
+	done := make(chan struct{})
+	e.semaphore <- struct{}{}
+	e.waitGroup.Add(1)
+	go func() {
+		// Close the channel when complete.
+		defer func() {
+			close(done)
+			<-e.semaphore
+			e.waitGroup.Done()
+		}()
+
+		if previous != nil {
+			<-previous
+		}
+
+		for i := 0; i < len(partitions); i++ {
+			log.Printf("msg=\"Processesing partition\" groupIdx=%d partitionIdx=%d", groupIdx, i)
+			batchSuccessful, batchLost := sendBatch(e.queueUrl, e.fifo, e.maxAttempts, partitions[i], e.sqsSvc)
+
+			// Evaluate the response.
+			atomic.AddInt32(&e.successful, batchSuccessful)
+			e.lostMutex.Lock()
+			e.lost = append(e.lost, batchLost...)
+			e.lostMutex.Unlock()
+		}
+	}()
+
+	return done
+}
+
+// Sends whatever is pending and waits for every batch, returning how many messages succeeded and the EventIDs of
+// those that were lost.
+func (e *enqueuer) wait() (int32, []string) {
+	if e.fifo {
+		e.flushGroups()
+	} else if len(e.pending) > 0 {
+		e.send([][]*message{e.pending}, nil)
+	}
+	e.pending, e.pendingBytes = nil, 0
+	e.waitGroup.Wait()
+	log.Printf("msg=\"Processed partitions\" groupsLen=%d fifo=%t", e.groups, e.fifo)
+
+	return e.successful, e.lost
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudtrail/cloudtrailiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/dlabey/iam-git-auditor/pkg/cloudtrail"
+	"github.com/dlabey/iam-git-auditor/pkg/queue"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	awscloudtrail "github.com/aws/aws-sdk-go/service/cloudtrail"
+)
+
+// How CloudTrail log files are validated against their digest files, set by LOG_FILE_VALIDATION. Flagging sends the
+// events of any log file along with its result, while rejecting only sends those of verified log files. Either way the
+// log files are tailed when S3 notifies of the digest file covering them rather than of the log files themselves, so
+// nothing is tailed from a trail that does not deliver digest files.
+const (
+	validationOff    = ""
+	validationFlag   = "flag"
+	validationReject = "reject"
+)
+
+// The entry of the digest file covering a log file, along with the result of validating the digest file itself.
+type digestEntry struct {
+	logFile    *cloudtrail.DigestLogFile
+	validation string
+}
+
+type validator struct {
+	mode          string
+	s3Svc         s3iface.S3API
+	cloudTrailSvc cloudtrailiface.CloudTrailAPI
+}
+
+// Returns how log files are validated, failing on an unknown LOG_FILE_VALIDATION rather than guessing whether to
+// reject log files.
+func validationMode() (string, error) {
+	mode := os.Getenv("LOG_FILE_VALIDATION")
+	switch mode {
+	case validationOff, validationFlag, validationReject:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("LOG_FILE_VALIDATION must be empty, %s or %s but is %s", validationFlag, validationReject,
+		mode)
+}
+
+func newValidator(s3Svc s3iface.S3API, cloudTrailSvc cloudtrailiface.CloudTrailAPI) (*validator, error) {
+	mode, err := validationMode()
+	if err != nil {
+		return nil, err
+	}
+
+	return &validator{
+		mode:          mode,
+		s3Svc:         s3Svc,
+		cloudTrailSvc: cloudTrailSvc,
+	}, nil
+}
+
+// Gets a decompressed S3 object whole along with its metadata.
+func (v *validator) getObject(bucket string, key string) ([]byte, map[string]*string, error) {
+	getObjectOutput, err := v.s3Svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer getObjectOutput.Body.Close()
+	body, err := decompress(getObjectOutput, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := ioutil.ReadAll(body)
+
+	return content, getObjectOutput.Metadata, err
+}
+
+// Returns an S3 object metadata value regardless of how its key was canonicalized.
+func metadataValue(metadata map[string]*string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) {
+			return aws.StringValue(v)
+		}
+	}
+
+	return ""
+}
+
+// Gets the CloudTrail public key the digest file was signed with, returning nil if CloudTrail lists no public key with
+// its fingerprint.
+func (v *validator) publicKey(digest *cloudtrail.Digest) (*rsa.PublicKey, error) {
+	startTime, err := time.Parse(time.RFC3339, digest.DigestStartTime)
+	if err != nil {
+		return nil, err
+	}
+	endTime, err := time.Parse(time.RFC3339, digest.DigestEndTime)
+	if err != nil {
+		return nil, err
+	}
+	listPublicKeysInput := &awscloudtrail.ListPublicKeysInput{
+		StartTime: aws.Time(startTime),
+		EndTime:   aws.Time(endTime),
+	}
+	for {
+		listPublicKeysOutput, err := v.cloudTrailSvc.ListPublicKeys(listPublicKeysInput)
+		if err != nil {
+			return nil, err
+		}
+		for _, publicKey := range listPublicKeysOutput.PublicKeyList {
+			if aws.StringValue(publicKey.Fingerprint) == digest.DigestPublicKeyFingerprint {
+				return x509.ParsePKCS1PublicKey(publicKey.Value)
+			}
+		}
+		if listPublicKeysOutput.NextToken == nil {
+			break
+		}
+		listPublicKeysInput.NextToken = listPublicKeysOutput.NextToken
+	}
+
+	return nil, nil
+}
+
+// Verifies the signature CloudTrail put in the digest file metadata over its data signing string.
+func verifyDigest(publicKey *rsa.PublicKey, digest *cloudtrail.Digest, content []byte,
+	metadata map[string]*string) error {
+	signature, err := hex.DecodeString(metadataValue(metadata, "signature"))
+	if err != nil {
+		return err
+	}
+	if len(signature) == 0 {
+		return errors.New("digest file has no signature")
+	}
+	previousDigestSignature := digest.PreviousDigestSignature
+	if previousDigestSignature == "" {
+		previousDigestSignature = "null"
+	}
+	contentHash := sha256.Sum256(content)
+	dataSigningString := digest.DigestEndTime + "\n" + digest.DigestS3Bucket + "/" + digest.DigestS3Object + "\n" +
+		hex.EncodeToString(contentHash[:]) + "\n" + previousDigestSignature
+	dataSigningHash := sha256.Sum256([]byte(dataSigningString))
+
+	return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, dataSigningHash[:], signature)
+}
+
+// Whether the S3 object is a digest file rather than a log file.
+func isDigestKey(key string) bool {
+	logFileKey, err := cloudtrail.ParseLogFileKey(key)
+
+	return err == nil && logFileKey.Kind == cloudtrail.DigestKind
+}
+
+// Warns when no digest file was ever delivered for the account and region of a log file, whose events are then never
+// tailed, which is the case of a trail without log file validation enabled.
+func (v *validator) checkDigestDelivery(bucket string, key string) {
+	logFileKey, err := cloudtrail.ParseLogFileKey(key)
+	if err != nil {
+		log.Printf("msg=\"Error parsing CloudTrail key\" key=\"%s\" err=\"%s\"", key, err)
+
+		return
+	}
+	digestPrefix := logFileKey.DigestPrefix()
+	listObjectsOutput, err := v.s3Svc.ListObjectsV2(&s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		MaxKeys: aws.Int64(1),
+		Prefix:  aws.String(digestPrefix),
+	})
+	if err != nil {
+		log.Printf("msg=\"Error listing digest files\" bucket=\"%s\" prefix=\"%s\" err=\"%s\"", bucket, digestPrefix,
+			err)
+
+		return
+	}
+	if aws.Int64Value(listObjectsOutput.KeyCount) == 0 {
+		log.Printf("msg=\"No digest file delivered yet, the log file is not tailed until one covers it\" key=\"%s\" "+
+			"digestPrefix=\"%s\" hint=\"Enable log file validation on the trail or unset LOG_FILE_VALIDATION\"", key,
+			digestPrefix)
+	}
+}
+
+// Gets the digest file and validates it was signed by CloudTrail, returning it along with the validation result that
+// carries over to each of the log files it covers.
+func (v *validator) validateDigest(bucket string, key string) (*cloudtrail.Digest, string, error) {
+	content, metadata, err := v.getObject(bucket, key)
+	if err != nil {
+		return nil, "", err
+	}
+	var digest cloudtrail.Digest
+	err = json.Unmarshal(content, &digest)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Check the digest file is the one CloudTrail signed. Failing to list the public keys, such as when throttled, fails
+	// the digest file for it to be retried rather than leaving the log files it covers unverifiable.
+	publicKey, err := v.publicKey(&digest)
+	if err != nil {
+		return nil, "", err
+	}
+	if publicKey == nil {
+		log.Printf("msg=\"No CloudTrail public key with the digest file fingerprint\" key=\"%s\" fingerprint=\"%s\"",
+			key, digest.DigestPublicKeyFingerprint)
+
+		return &digest, queue.LogFileUnverifiable, nil
+	}
+	err = verifyDigest(publicKey, &digest, content, metadata)
+	if err != nil {
+		log.Printf("msg=\"Digest file signature is invalid\" key=\"%s\" err=\"%s\"", key, err)
+
+		return &digest, queue.LogFileInvalid, nil
+	}
+
+	return &digest, queue.LogFileVerified, nil
+}
+
+// Validates a log file against the entry of the digest file covering it, hashing its decompressed content as it is
+// read rather than holding it whole. Returns the result along with the ETag of the S3 object that was hashed.
+func (v *validator) validateLogFile(bucket string, key string, digestEntry *digestEntry) (string, *string, error) {
+	if digestEntry.validation != queue.LogFileVerified {
+		return digestEntry.validation, nil, nil
+	}
+	getObjectOutput, err := v.s3Svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	defer getObjectOutput.Body.Close()
+	body, err := decompress(getObjectOutput, key)
+	if err != nil {
+		return "", nil, err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, body)
+	if err != nil {
+		return "", nil, err
+	}
+	hashValue := hex.EncodeToString(hash.Sum(nil))
+	if hashValue != digestEntry.logFile.HashValue {
+		log.Printf("msg=\"Log file hash does not match its digest\" key=\"%s\" hashValue=\"%s\" digestHashValue=\"%s\"",
+			key, hashValue, digestEntry.logFile.HashValue)
+
+		return queue.LogFileInvalid, getObjectOutput.ETag, nil
+	}
+
+	return queue.LogFileVerified, getObjectOutput.ETag, nil
+}
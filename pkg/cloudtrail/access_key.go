@@ -0,0 +1,10 @@
+package cloudtrail
+
+// The metadata of an access key. It deliberately has no field for the secret access key, so that the secret is
+// dropped when the event is decoded and can never be sent on or written.
+type AccessKey struct {
+	AccessKeyID string `json:"accessKeyId,omitempty"`
+	CreateDate  string `json:"createDate,omitempty"`
+	Status      string `json:"status,omitempty"`
+	UserName    string `json:"userName,omitempty"`
+}
@@ -0,0 +1,13 @@
+package cloudtrail
+
+type Digest struct {
+	AWSAccountID               string          `json:"awsAccountId,omitempty"`
+	DigestEndTime              string          `json:"digestEndTime,omitempty"`
+	DigestPublicKeyFingerprint string          `json:"digestPublicKeyFingerprint,omitempty"`
+	DigestS3Bucket             string          `json:"digestS3Bucket,omitempty"`
+	DigestS3Object             string          `json:"digestS3Object,omitempty"`
+	DigestSignatureAlgorithm   string          `json:"digestSignatureAlgorithm,omitempty"`
+	DigestStartTime            string          `json:"digestStartTime,omitempty"`
+	LogFiles                   []DigestLogFile `json:"logFiles,omitempty"`
+	PreviousDigestSignature    string          `json:"previousDigestSignature,omitempty"`
+}
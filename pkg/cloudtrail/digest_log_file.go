@@ -0,0 +1,8 @@
+package cloudtrail
+
+type DigestLogFile struct {
+	HashAlgorithm string `json:"hashAlgorithm,omitempty"`
+	HashValue     string `json:"hashValue,omitempty"`
+	S3Bucket      string `json:"s3Bucket,omitempty"`
+	S3Object      string `json:"s3Object,omitempty"`
+}
@@ -0,0 +1,16 @@
+package cloudtrail
+
+import "strings"
+
+// Returns the name of a Lambda function, which requests may give as a name, a partial ARN or a full ARN, with or
+// without a qualifier.
+func FunctionName(functionName string) string {
+	segments := strings.Split(functionName, ":")
+	for i := 0; i < len(segments)-1; i++ {
+		if segments[i] == "function" {
+			return segments[i+1]
+		}
+	}
+
+	return segments[0]
+}
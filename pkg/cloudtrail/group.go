@@ -0,0 +1,9 @@
+package cloudtrail
+
+type Group struct {
+	Arn        string `json:"arn,omitempty"`
+	CreateDate string `json:"createDate,omitempty"`
+	GroupID    string `json:"groupId,omitempty"`
+	GroupName  string `json:"groupName,omitempty"`
+	Path       string `json:"path,omitempty"`
+}
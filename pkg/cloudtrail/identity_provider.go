@@ -0,0 +1,14 @@
+package cloudtrail
+
+import "strings"
+
+// Returns the name of an IAM identity provider from its ARN, which is what follows the provider type: the name given
+// to a SAML provider, or the URL without its scheme of an OIDC provider.
+func IdentityProviderName(arn string) string {
+	return arn[strings.Index(arn, "/")+1:]
+}
+
+// Returns the name an OIDC provider gets from its URL, as it is when creating the provider.
+func OpenIDConnectProviderName(url string) string {
+	return strings.TrimPrefix(url, "https://")
+}
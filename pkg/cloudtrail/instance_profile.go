@@ -0,0 +1,9 @@
+package cloudtrail
+
+type InstanceProfile struct {
+	Arn                 string `json:"arn,omitempty"`
+	CreateDate          string `json:"createDate,omitempty"`
+	InstanceProfileID   string `json:"instanceProfileId,omitempty"`
+	InstanceProfileName string `json:"instanceProfileName,omitempty"`
+	Path                string `json:"path,omitempty"`
+}
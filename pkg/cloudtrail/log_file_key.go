@@ -0,0 +1,84 @@
+package cloudtrail
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The kinds of files CloudTrail delivers, which it keeps in directories of their own.
+const (
+	LogFileKind = "CloudTrail"
+	DigestKind  = "CloudTrail-Digest"
+)
+
+// An AWS account ID, which is always 12 digits.
+var accountIDPattern = regexp.MustCompile(`^\d{12}$`)
+
+// The parts of the S3 key CloudTrail delivers a log file to, which is either
+// [<prefix>/]AWSLogs/<account>/CloudTrail/<region>/YYYY/MM/DD/<file> for a trail of a single account or
+// [<prefix>/]AWSLogs/<org-id>/<account>/CloudTrail/<region>/YYYY/MM/DD/<file> for an organization trail.
+type LogFileKey struct {
+	Prefix         string
+	OrganizationID string
+	AccountID      string
+	Kind           string
+	Region         string
+	Date           time.Time
+	FileName       string
+}
+
+// Parses the S3 key of a CloudTrail log file or digest file.
+func ParseLogFileKey(key string) (*LogFileKey, error) {
+	segments := strings.Split(key, "/")
+	awsLogsIdx := -1
+	for i := 0; i < len(segments); i++ {
+		if segments[i] == "AWSLogs" {
+			awsLogsIdx = i
+			break
+		}
+	}
+	if awsLogsIdx < 0 {
+		return nil, fmt.Errorf("no AWSLogs in CloudTrail key %s", key)
+	}
+	logFileKey := &LogFileKey{
+		Prefix: strings.Join(segments[:awsLogsIdx], "/"),
+	}
+	segments = segments[awsLogsIdx+1:]
+
+	// An organization trail nests the accounts under the organization.
+	if len(segments) > 0 && strings.HasPrefix(segments[0], "o-") {
+		logFileKey.OrganizationID, segments = segments[0], segments[1:]
+	}
+	if len(segments) != 7 || !accountIDPattern.MatchString(segments[0]) ||
+		(segments[1] != LogFileKind && segments[1] != DigestKind) {
+		return nil, fmt.Errorf("unexpected CloudTrail key layout %s", key)
+	}
+	date, err := time.Parse("2006/01/02", strings.Join(segments[3:6], "/"))
+	if err != nil {
+		return nil, fmt.Errorf("unexpected CloudTrail key date %s: %s", key, err)
+	}
+	logFileKey.AccountID = segments[0]
+	logFileKey.Kind = segments[1]
+	logFileKey.Region = segments[2]
+	logFileKey.Date = date
+	logFileKey.FileName = segments[6]
+
+	return logFileKey, nil
+}
+
+// Returns the S3 key prefix CloudTrail delivers the digest files of the same account and region under.
+func (k *LogFileKey) DigestPrefix() string {
+	var segments []string
+	if k.Prefix != "" {
+		segments = append(segments, k.Prefix)
+	}
+	segments = append(segments, "AWSLogs")
+	if k.OrganizationID != "" {
+		segments = append(segments, k.OrganizationID)
+	}
+	segments = append(segments, k.AccountID, DigestKind, k.Region, "")
+
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,8 @@
+package cloudtrail
+
+// The metadata of a console password, which has no field for the password for the same reason as AccessKey.
+type LoginProfile struct {
+	CreateDate            string `json:"createDate,omitempty"`
+	PasswordResetRequired bool   `json:"passwordResetRequired,omitempty"`
+	UserName              string `json:"userName,omitempty"`
+}
@@ -0,0 +1,7 @@
+package cloudtrail
+
+type MFADevice struct {
+	EnableDate   string `json:"enableDate,omitempty"`
+	SerialNumber string `json:"serialNumber,omitempty"`
+	UserName     string `json:"userName,omitempty"`
+}
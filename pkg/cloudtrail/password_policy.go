@@ -0,0 +1,14 @@
+package cloudtrail
+
+// The account password policy, with every setting kept as UpdateAccountPasswordPolicy replaces the whole policy.
+type PasswordPolicy struct {
+	AllowUsersToChangePassword bool `json:"allowUsersToChangePassword"`
+	HardExpiry                 bool `json:"hardExpiry"`
+	MaxPasswordAge             int  `json:"maxPasswordAge"`
+	MinimumPasswordLength      int  `json:"minimumPasswordLength"`
+	PasswordReusePrevention    int  `json:"passwordReusePrevention"`
+	RequireLowercaseCharacters bool `json:"requireLowercaseCharacters"`
+	RequireNumbers             bool `json:"requireNumbers"`
+	RequireSymbols             bool `json:"requireSymbols"`
+	RequireUppercaseCharacters bool `json:"requireUppercaseCharacters"`
+}
@@ -0,0 +1,10 @@
+package cloudtrail
+
+type PermissionSet struct {
+	CreatedDate      string `json:"createdDate,omitempty"`
+	Description      string `json:"description,omitempty"`
+	Name             string `json:"name,omitempty"`
+	PermissionSetArn string `json:"permissionSetArn,omitempty"`
+	RelayState       string `json:"relayState,omitempty"`
+	SessionDuration  string `json:"sessionDuration,omitempty"`
+}
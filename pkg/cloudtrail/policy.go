@@ -0,0 +1,14 @@
+package cloudtrail
+
+// A managed policy, which CloudTrail records under the same name for IAM and for Organizations, where it is a
+// summary along with the content instead.
+type Policy struct {
+	Arn              string        `json:"arn,omitempty"`
+	Content          string        `json:"content,omitempty"`
+	CreateDate       string        `json:"createDate,omitempty"`
+	DefaultVersionId string        `json:"defaultVersionId,omitempty"`
+	Path             string        `json:"path,omitempty"`
+	PolicyId         string        `json:"policyId,omitempty"`
+	PolicyName       string        `json:"policyName,omitempty"`
+	PolicySummary    PolicySummary `json:"policySummary,omitempty"`
+}
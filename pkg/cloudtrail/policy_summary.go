@@ -0,0 +1,10 @@
+package cloudtrail
+
+type PolicySummary struct {
+	Arn         string `json:"arn,omitempty"`
+	AwsManaged  bool   `json:"awsManaged,omitempty"`
+	Description string `json:"description,omitempty"`
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Type        string `json:"type,omitempty"`
+}
@@ -1,5 +1,7 @@
 package cloudtrail
 
 type PolicyVersion struct {
-	VersionId string `json:"versionId,omitempty"`
+	CreateDate       string `json:"createDate,omitempty"`
+	IsDefaultVersion bool   `json:"isDefaultVersion,omitempty"`
+	VersionId        string `json:"versionId,omitempty"`
 }
@@ -1,9 +1,74 @@
 package cloudtrail
 
+import "encoding/json"
+
 type RequestParameters struct {
-	PolicyArn      string `json:"policyArn,omitempty"`
-	PolicyDocument string `json:"policyDocument,omitempty"`
-	PolicyName     string `json:"policyName,omitempty"`
-	RoleName       string `json:"roleName,omitempty"`
-	VersionId      string `json:"versionId,omitempty"`
+	AccessKeyID                string            `json:"accessKeyId,omitempty"`
+	AccountAlias               string            `json:"accountAlias,omitempty"`
+	Action                     string            `json:"action,omitempty"`
+	AllowUsersToChangePassword bool              `json:"allowUsersToChangePassword,omitempty"`
+	AssumeRolePolicyDocument   string            `json:"assumeRolePolicyDocument,omitempty"`
+	AttributeName              string            `json:"attributeName,omitempty"`
+	AttributeValue             string            `json:"attributeValue,omitempty"`
+	Attributes                 map[string]string `json:"attributes,omitempty"`
+	BucketName                 string            `json:"bucketName,omitempty"`
+	BucketPolicy               json.RawMessage   `json:"bucketPolicy,omitempty"`
+	ClientID                   string            `json:"clientID,omitempty"`
+	ClientIDList               []string          `json:"clientIDList,omitempty"`
+	Content                    string            `json:"content,omitempty"`
+	Description                string            `json:"description,omitempty"`
+	FunctionName               string            `json:"functionName,omitempty"`
+	GlobalEndpointTokenVersion string            `json:"globalEndpointTokenVersion,omitempty"`
+	GroupName                  string            `json:"groupName,omitempty"`
+	HardExpiry                 bool              `json:"hardExpiry,omitempty"`
+	InlinePolicy               string            `json:"inlinePolicy,omitempty"`
+	InstanceArn                string            `json:"instanceArn,omitempty"`
+	InstanceProfileName        string            `json:"instanceProfileName,omitempty"`
+	KeyID                      string            `json:"keyId,omitempty"`
+	ManagedPolicyArn           string            `json:"managedPolicyArn,omitempty"`
+	MaxPasswordAge             int               `json:"maxPasswordAge,omitempty"`
+	MaxSessionDuration         int               `json:"maxSessionDuration,omitempty"`
+	MinimumPasswordLength      int               `json:"minimumPasswordLength,omitempty"`
+	Name                       string            `json:"name,omitempty"`
+	OpenIDConnectProviderArn   string            `json:"openIDConnectProviderArn,omitempty"`
+	PasswordResetRequired      bool              `json:"passwordResetRequired,omitempty"`
+	PasswordReusePrevention    int               `json:"passwordReusePrevention,omitempty"`
+	Path                       string            `json:"path,omitempty"`
+	PermissionSetArn           string            `json:"permissionSetArn,omitempty"`
+	PermissionsBoundary        string            `json:"permissionsBoundary,omitempty"`
+	Policy                     string            `json:"policy,omitempty"`
+	PolicyArn                  string            `json:"policyArn,omitempty"`
+	PolicyDocument             string            `json:"policyDocument,omitempty"`
+	PolicyId                   string            `json:"policyId,omitempty"`
+	PolicyName                 string            `json:"policyName,omitempty"`
+	Principal                  string            `json:"principal,omitempty"`
+	PrincipalId                string            `json:"principalId,omitempty"`
+	PrincipalOrgID             string            `json:"principalOrgID,omitempty"`
+	PrincipalType              string            `json:"principalType,omitempty"`
+	QueueURL                   string            `json:"queueUrl,omitempty"`
+	RelayState                 string            `json:"relayState,omitempty"`
+	RequireLowercaseCharacters bool              `json:"requireLowercaseCharacters,omitempty"`
+	RequireNumbers             bool              `json:"requireNumbers,omitempty"`
+	RequireSymbols             bool              `json:"requireSymbols,omitempty"`
+	RequireUppercaseCharacters bool              `json:"requireUppercaseCharacters,omitempty"`
+	RoleName                   string            `json:"roleName,omitempty"`
+	SAMLMetadataDocument       string            `json:"sAMLMetadataDocument,omitempty"`
+	SAMLProviderArn            string            `json:"sAMLProviderArn,omitempty"`
+	SerialNumber               string            `json:"serialNumber,omitempty"`
+	SessionDuration            string            `json:"sessionDuration,omitempty"`
+	SetAsDefault               bool              `json:"setAsDefault,omitempty"`
+	SourceAccount              string            `json:"sourceAccount,omitempty"`
+	SourceArn                  string            `json:"sourceArn,omitempty"`
+	StatementID                string            `json:"statementId,omitempty"`
+	Status                     string            `json:"status,omitempty"`
+	TagKeys                    []string          `json:"tagKeys,omitempty"`
+	Tags                       []Tag             `json:"tags,omitempty"`
+	TargetId                   string            `json:"targetId,omitempty"`
+	TargetType                 string            `json:"targetType,omitempty"`
+	ThumbprintList             []string          `json:"thumbprintList,omitempty"`
+	TopicArn                   string            `json:"topicArn,omitempty"`
+	Type                       string            `json:"type,omitempty"`
+	URL                        string            `json:"url,omitempty"`
+	UserName                   string            `json:"userName,omitempty"`
+	VersionId                  string            `json:"versionId,omitempty"`
 }
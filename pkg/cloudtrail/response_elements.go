@@ -1,6 +1,17 @@
 package cloudtrail
 
 type ResponseElements struct {
-	PolicyName    string        `json:"policyName,omitempty"`
-	PolicyVersion PolicyVersion `json:"policyVersion,omitempty"`
+	AccessKey                AccessKey       `json:"accessKey,omitempty"`
+	Group                    Group           `json:"group,omitempty"`
+	InstanceProfile          InstanceProfile `json:"instanceProfile,omitempty"`
+	LoginProfile             LoginProfile    `json:"loginProfile,omitempty"`
+	OpenIDConnectProviderArn string          `json:"openIDConnectProviderArn,omitempty"`
+	PermissionSet            PermissionSet   `json:"permissionSet,omitempty"`
+	Policy                   Policy          `json:"policy,omitempty"`
+	PolicyName               string          `json:"policyName,omitempty"`
+	PolicyVersion            PolicyVersion   `json:"policyVersion,omitempty"`
+	Role                     Role            `json:"role,omitempty"`
+	SAMLProviderArn          string          `json:"sAMLProviderArn,omitempty"`
+	Statement                string          `json:"statement,omitempty"`
+	User                     User            `json:"user,omitempty"`
 }
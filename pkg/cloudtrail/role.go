@@ -1,5 +1,11 @@
 package cloudtrail
 
 type Role struct {
-	RoleName string `json:"roleName,omitempty"`
+	Arn                string `json:"arn,omitempty"`
+	CreateDate         string `json:"createDate,omitempty"`
+	Description        string `json:"description,omitempty"`
+	MaxSessionDuration int    `json:"maxSessionDuration,omitempty"`
+	Path               string `json:"path,omitempty"`
+	RoleID             string `json:"roleId,omitempty"`
+	RoleName           string `json:"roleName,omitempty"`
 }
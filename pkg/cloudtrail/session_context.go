@@ -0,0 +1,5 @@
+package cloudtrail
+
+type SessionContext struct {
+	SessionIssuer SessionIssuer `json:"sessionIssuer,omitempty"`
+}
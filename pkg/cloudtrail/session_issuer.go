@@ -0,0 +1,8 @@
+package cloudtrail
+
+type SessionIssuer struct {
+	Arn         string `json:"arn,omitempty"`
+	PrincipalID string `json:"principalId,omitempty"`
+	Type        string `json:"type,omitempty"`
+	UserName    string `json:"userName,omitempty"`
+}
@@ -0,0 +1,6 @@
+package cloudtrail
+
+type Tag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
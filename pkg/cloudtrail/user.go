@@ -0,0 +1,9 @@
+package cloudtrail
+
+type User struct {
+	Arn        string `json:"arn,omitempty"`
+	CreateDate string `json:"createDate,omitempty"`
+	Path       string `json:"path,omitempty"`
+	UserID     string `json:"userId,omitempty"`
+	UserName   string `json:"userName,omitempty"`
+}
@@ -1,6 +1,25 @@
 package cloudtrail
 
+import "strings"
+
 type UserIdentity struct {
-	Type     string `json:"type,omitempty"`
-	UserName string `json:"username,omitempty"`
+	AccountID      string         `json:"accountId,omitempty"`
+	Arn            string         `json:"arn,omitempty"`
+	PrincipalID    string         `json:"principalId,omitempty"`
+	SessionContext SessionContext `json:"sessionContext,omitempty"`
+	Type           string         `json:"type,omitempty"`
+	UserName       string         `json:"userName,omitempty"`
+}
+
+// Returns who made the request. Only IAM users have a user name, so assumed roles and the root user are named after
+// the resource of their ARN, e.g. assumed-role/<role>/<session>.
+func (u *UserIdentity) Name() string {
+	if u.UserName != "" {
+		return u.UserName
+	}
+	if u.Arn != "" {
+		return u.Arn[strings.LastIndex(u.Arn, ":")+1:]
+	}
+
+	return u.Type
 }
@@ -0,0 +1,18 @@
+package queue
+
+// The SQS message attributes the Tailer sets for the Auditor.
+const (
+	AccountIDAttribute         = "AccountId"
+	LogFileValidationAttribute = "LogFileValidation"
+	LogObjectAttribute         = "LogObject"
+	OverflowBucketAttribute    = "OverflowBucket"
+	OverflowKeyAttribute       = "OverflowKey"
+	RegionAttribute            = "Region"
+)
+
+// The results of validating a CloudTrail log file against its digest file.
+const (
+	LogFileInvalid      = "invalid"
+	LogFileUnverifiable = "unverifiable"
+	LogFileVerified     = "verified"
+)